@@ -0,0 +1,475 @@
+package goflatdb
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+)
+
+const changeLogFileName = "changes.log"
+
+// ChangeOp is the kind of write a ChangeEvent reports.
+type ChangeOp uint8
+
+const (
+	ChangeInsert ChangeOp = iota + 1
+	ChangeUpdate
+	ChangeDelete
+)
+
+func (op ChangeOp) String() string {
+	switch op {
+	case ChangeInsert:
+		return "insert"
+	case ChangeUpdate:
+		return "update"
+	case ChangeDelete:
+		return "delete"
+	default:
+		return fmt.Sprintf("ChangeOp(%d)", uint8(op))
+	}
+}
+
+// ChangeEvent is a write to a document of a collection. Doc is the document after an insert or update,
+// and the deleted document for a delete. Seq numbers events of a collection in the order of their writes.
+type ChangeEvent[T any] struct {
+	Seq uint64
+	Op  ChangeOp
+	ID  uint64
+	Doc FlatDBModel[T]
+}
+
+// changeRecord is an entry of the change log. Data is the encoded document.
+type changeRecord struct {
+	Seq  uint64   `json:"seq"`
+	Op   ChangeOp `json:"op"`
+	ID   uint64   `json:"id"`
+	Data []byte   `json:"data"`
+}
+
+// changeLog is the append-only log of the writes to a collection, stored as entries framed like the
+// write-ahead log. Readers only read up to size, so they never see an entry that is still being written.
+type changeLog struct {
+	f          *os.File
+	durability Durability
+
+	lastSeq uint64
+	size    int64
+	pending []changeRecord // records whose append failed, appended again before the next one
+	notify  chan struct{}  // closed and replaced whenever entries are appended
+	closed  bool
+}
+
+func openChangeLog(path string, durability Durability) (*changeLog, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, errorOpeningChangeLog(path, err)
+	}
+
+	l := &changeLog{
+		f:          f,
+		durability: durability,
+		notify:     make(chan struct{}),
+	}
+
+	// a torn tail left by a crash is cut off, so new entries are appended after the last complete one
+	r := bufio.NewReader(f)
+	for {
+		record, n, err := readChangeRecord(r)
+		if err != nil {
+			break
+		}
+
+		l.lastSeq = record.Seq
+		l.size += n
+	}
+
+	if err := f.Truncate(l.size); err != nil {
+		return nil, errorOpeningChangeLog(path, err)
+	}
+
+	if _, err := f.Seek(l.size, io.SeekStart); err != nil {
+		return nil, errorOpeningChangeLog(path, err)
+	}
+
+	return l, nil
+}
+
+// nextSeq returns the sequence number of the next record appended to the log.
+func (l *changeLog) nextSeq() uint64 {
+	return l.lastSeq + uint64(len(l.pending)) + 1
+}
+
+// append records a write and wakes up the streams waiting for it. A record that fails to be written is kept
+// and written before the next one. Caller must hold the collection lock.
+func (l *changeLog) append(op ChangeOp, id uint64, data []byte) error {
+	l.pending = append(l.pending, changeRecord{Seq: l.nextSeq(), Op: op, ID: id, Data: data})
+
+	for len(l.pending) > 0 {
+		if err := l.write(l.pending[0]); err != nil {
+			return err
+		}
+		l.pending = l.pending[1:]
+	}
+
+	return nil
+}
+
+func (l *changeLog) write(record changeRecord) error {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return errorAppendingToChangeLog(err)
+	}
+
+	entry := encodeLogEntry(payload)
+	if _, err := l.f.Write(entry); err != nil {
+		// a partially written entry would be followed by the next one, cut it off
+		_ = l.f.Truncate(l.size)
+		_, _ = l.f.Seek(l.size, io.SeekStart)
+		return errorAppendingToChangeLog(err)
+	}
+
+	if l.durability >= DurabilityFileSync {
+		if err := l.f.Sync(); err != nil {
+			return errorAppendingToChangeLog(err)
+		}
+	}
+
+	l.lastSeq = record.Seq
+	l.size += int64(len(entry))
+
+	close(l.notify)
+	l.notify = make(chan struct{})
+
+	return nil
+}
+
+// Close wakes up the waiting streams, which end once they have read the remaining entries.
+// Closing a closed log does nothing. Caller must hold the collection lock.
+func (l *changeLog) Close() error {
+	if l.closed {
+		return nil
+	}
+
+	l.closed = true
+	close(l.notify)
+
+	return l.f.Close()
+}
+
+// readChangeRecord reads an entry of the change log, returning it and its size.
+func readChangeRecord(r io.Reader) (changeRecord, int64, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return changeRecord{}, 0, err
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return changeRecord{}, 0, err
+	}
+
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return changeRecord{}, 0, errors.New("checksum mismatch")
+	}
+
+	record := changeRecord{}
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return changeRecord{}, 0, err
+	}
+
+	return record, int64(len(header) + len(payload)), nil
+}
+
+// withChange returns record with the sequence number its change event will get, if the collection has
+// a change log, and old, the encoded document a delete removes. The intent then carries the event,
+// so it is recorded when the write is replayed after a crash. Caller must hold c.mu.
+func (c *FlatDBCollection[T]) withChange(record walRecord, old []byte) walRecord {
+	if c.changeLog == nil {
+		return record
+	}
+
+	record.Seq = c.changeLog.nextSeq()
+	if record.Op == walOpDelete {
+		record.Old = old
+	}
+
+	return record
+}
+
+// change returns the change event of the write record logs.
+func (r walRecord) change() (ChangeOp, []byte) {
+	switch r.Op {
+	case walOpInsert:
+		return ChangeInsert, r.Data
+	case walOpUpdate:
+		return ChangeUpdate, r.Data
+	default:
+		return ChangeDelete, r.Old
+	}
+}
+
+// recordChange appends the change event of an applied write to the change log, if record has one. The write has
+// already been applied, so a failure is logged rather than returned, and the event is appended with the next one.
+// Caller must hold c.mu.
+func (c *FlatDBCollection[T]) recordChange(record walRecord) {
+	if c.changeLog == nil || record.Seq == 0 {
+		return
+	}
+
+	op, data := record.change()
+	if err := c.changeLog.append(op, record.ID, data); err != nil {
+		c.logger.Error("error recording change", zap.Uint64("id", record.ID), zap.Stringer("op", op), zap.Error(err))
+	}
+}
+
+// replayChange appends the change event of a replayed write, unless it was recorded before the crash.
+// Caller must hold c.mu.
+func (c *FlatDBCollection[T]) replayChange(record walRecord) error {
+	if c.changeLog == nil || record.Seq == 0 || record.Seq <= c.changeLog.lastSeq {
+		return nil
+	}
+
+	op, data := record.change()
+	return c.changeLog.append(op, record.ID, data)
+}
+
+// recoverChange appends the change event of a write of an interrupted transaction to the change log
+// of the collection in colDir, unless it was recorded before the crash.
+func recoverChange(colDir string, record walRecord) error {
+	if record.Seq == 0 {
+		return nil
+	}
+
+	l, err := openChangeLog(filepath.Join(colDir, changeLogFileName), DurabilityFileSync)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+
+	if record.Seq <= l.lastSeq {
+		return nil
+	}
+
+	op, data := record.change()
+	return l.append(op, record.ID, data)
+}
+
+// ChangeStream delivers the change events of a collection in order. It is used like a Cursor, except that
+// Next waits for new events until the context passed to Watch is done or the collection is closed.
+type ChangeStream[T any] struct {
+	ctx   context.Context
+	col   *FlatDBCollection[T]
+	f     *os.File
+	match func(doc FlatDBModel[T]) (bool, error) // nil accepts every document
+
+	after   uint64 // events up to this sequence number are skipped
+	offset  int64
+	pending []changeRecord
+
+	event ChangeEvent[T]
+	err   error
+}
+
+// WatchOption configures a ChangeStream.
+type WatchOption func(o *watchOptions)
+
+type watchOptions struct {
+	after *uint64
+}
+
+// WatchAfter makes the stream start with the event following seq, so a consumer resumes where it left off
+// by passing the sequence number of the last event it has processed. WatchAfter(0) replays the whole change log.
+func WatchAfter(seq uint64) WatchOption {
+	return func(o *watchOptions) {
+		o.after = &seq
+	}
+}
+
+// Watch returns a stream of the writes to the collection, starting with the first write after Watch is called
+// unless WatchAfter is passed. Only events whose document matches filter are delivered, a nil filter matches
+// every document. The collection must have been created with WithChangeLog.
+//
+// Events are recorded once their write is applied. The event of a write interrupted by a crash is recorded
+// when Init replays the write, so every applied write has an event.
+func (c *FlatDBCollection[T]) Watch(ctx context.Context, filter *QueryBuilder[T], opts ...WatchOption) (*ChangeStream[T], error) {
+	o := watchOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var match func(doc FlatDBModel[T]) (bool, error)
+	if filter != nil {
+		var ok bool
+		match, ok = queryMatcher(filter.Q)
+		if !ok {
+			return nil, errorWatchingCollection(c.name, fmt.Errorf("%w: filter must match documents one by one", InvalidQuery))
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.changeLog == nil {
+		return nil, errorWatchingCollection(c.name, errors.New("collection has no change log"))
+	}
+
+	if c.changeLog.closed {
+		return nil, errorWatchingCollection(c.name, os.ErrClosed)
+	}
+
+	after := c.changeLog.lastSeq
+	if o.after != nil {
+		after = *o.after
+	}
+
+	f, err := os.Open(filepath.Join(c.dir.Name(), changeLogFileName))
+	if err != nil {
+		return nil, errorWatchingCollection(c.name, err)
+	}
+
+	return &ChangeStream[T]{
+		ctx:   ctx,
+		col:   c,
+		f:     f,
+		match: match,
+		after: after,
+	}, nil
+}
+
+// Next advances the stream to the next event, waiting for it if necessary. It returns false when the context
+// is done, the collection is closed, an error occurred or the stream was closed.
+func (s *ChangeStream[T]) Next() bool {
+	for s.err == nil && s.f != nil {
+		for len(s.pending) > 0 {
+			record := s.pending[0]
+			s.pending = s.pending[1:]
+
+			ok, err := s.accept(record)
+			if err != nil {
+				s.err = err
+				return false
+			}
+
+			if ok {
+				return true
+			}
+		}
+
+		if err := s.ctx.Err(); err != nil {
+			s.err = err
+			return false
+		}
+
+		s.col.mu.RLock()
+		size, notify, closed := s.col.changeLog.size, s.col.changeLog.notify, s.col.changeLog.closed
+		s.col.mu.RUnlock()
+
+		if s.offset < size {
+			if err := s.read(size); err != nil {
+				s.err = errorReadingChangeLog(err)
+				return false
+			}
+			continue
+		}
+
+		if closed {
+			return false
+		}
+
+		select {
+		case <-notify:
+		case <-s.ctx.Done():
+		}
+	}
+
+	return false
+}
+
+// read reads the entries up to size into pending.
+func (s *ChangeStream[T]) read(size int64) error {
+	r := bufio.NewReader(io.NewSectionReader(s.f, s.offset, size-s.offset))
+	for s.offset < size {
+		record, n, err := readChangeRecord(r)
+		if err != nil {
+			return err
+		}
+
+		s.offset += n
+		if record.Seq > s.after {
+			s.pending = append(s.pending, record)
+		}
+	}
+
+	return nil
+}
+
+// accept decodes record into the current event if its document matches the filter.
+func (s *ChangeStream[T]) accept(record changeRecord) (bool, error) {
+	doc, err := s.col.decodeDocument(record.Data)
+	if err != nil {
+		return false, errorReadingChangeLog(fmt.Errorf("event %d: %w", record.Seq, err))
+	}
+
+	if s.match != nil {
+		ok, err := s.match(doc)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	s.event = ChangeEvent[T]{Seq: record.Seq, Op: record.Op, ID: record.ID, Doc: doc}
+	s.after = record.Seq
+
+	return true, nil
+}
+
+// Event returns the event the stream points to.
+func (s *ChangeStream[T]) Event() ChangeEvent[T] {
+	return s.event
+}
+
+// Err returns the error that stopped the stream, if any.
+func (s *ChangeStream[T]) Err() error {
+	return s.err
+}
+
+// Close releases the stream.
+func (s *ChangeStream[T]) Close() error {
+	if s.f == nil {
+		return nil
+	}
+
+	err := s.f.Close()
+	s.f = nil
+	s.pending = nil
+
+	return err
+}
+
+func errorOpeningChangeLog(path string, err error) error {
+	return fmt.Errorf("error opening change log %s: %w", path, err)
+}
+
+func errorAppendingToChangeLog(err error) error {
+	return fmt.Errorf("error appending to change log: %w", err)
+}
+
+func errorReadingChangeLog(err error) error {
+	return fmt.Errorf("error reading change log: %w", err)
+}
+
+func errorWatchingCollection(collection string, err error) error {
+	return fmt.Errorf("error watching collection %s: %w", collection, err)
+}
@@ -0,0 +1,207 @@
+package goflatdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	db, err := NewFlatDB(dir, logger)
+	require.NoError(t, err)
+
+	open := func(t *testing.T) *FlatDBCollection[testData] {
+		col, err := NewFlatDBCollection[testData](db, "test-collection", logger, WithChangeLog[testData]())
+		require.NoError(t, err)
+		return col
+	}
+
+	type event struct {
+		seq uint64
+		op  ChangeOp
+		id  uint64
+		foo string
+	}
+	next := func(t *testing.T, stream *ChangeStream[testData]) event {
+		require.True(t, stream.Next(), stream.Err())
+		e := stream.Event()
+		return event{seq: e.Seq, op: e.Op, id: e.ID, foo: e.Doc.Data.Foo}
+	}
+
+	ctx := context.Background()
+	col := open(t)
+
+	all, err := col.Watch(ctx, nil)
+	require.NoError(t, err)
+	defer all.Close()
+
+	filtered, err := col.Watch(ctx, col.QueryBuilder().Where("foo", "=", "b"))
+	require.NoError(t, err)
+	defer filtered.Close()
+
+	_, err = col.Insert(&testData{Foo: "a"})
+	require.NoError(t, err)
+	_, err = col.Insert(&testData{Foo: "b"})
+	require.NoError(t, err)
+	require.NoError(t, col.Update(1, &testData{Foo: "c"}))
+	require.NoError(t, col.Delete(2))
+
+	require.Equal(t, event{seq: 1, op: ChangeInsert, id: 1, foo: "a"}, next(t, all))
+	require.Equal(t, event{seq: 2, op: ChangeInsert, id: 2, foo: "b"}, next(t, all))
+	require.Equal(t, event{seq: 3, op: ChangeUpdate, id: 1, foo: "c"}, next(t, all))
+	require.Equal(t, event{seq: 4, op: ChangeDelete, id: 2, foo: "b"}, next(t, all))
+
+	require.Equal(t, event{seq: 2, op: ChangeInsert, id: 2, foo: "b"}, next(t, filtered))
+	require.Equal(t, event{seq: 4, op: ChangeDelete, id: 2, foo: "b"}, next(t, filtered))
+
+	t.Run("next waits for new events", func(t *testing.T) {
+		events := make(chan event)
+		go func() {
+			if all.Next() {
+				e := all.Event()
+				events <- event{seq: e.Seq, op: e.Op, id: e.ID, foo: e.Doc.Data.Foo}
+			}
+			close(events)
+		}()
+
+		select {
+		case e := <-events:
+			t.Fatalf("unexpected event %v", e)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		tx := db.Begin()
+		_, err := col.InsertTx(tx, &testData{Foo: "d"})
+		require.NoError(t, err)
+		require.NoError(t, tx.Commit())
+
+		require.Equal(t, event{seq: 5, op: ChangeInsert, id: 3, foo: "d"}, <-events)
+	})
+
+	t.Run("next stops when the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+
+		stream, err := col.Watch(ctx, nil)
+		require.NoError(t, err)
+		defer stream.Close()
+
+		require.False(t, stream.Next())
+		require.ErrorIs(t, stream.Err(), context.DeadlineExceeded)
+	})
+
+	t.Run("streams resume after a restart", func(t *testing.T) {
+		require.NoError(t, col.Close())
+		require.NoError(t, col.Close())
+		require.False(t, all.Next())
+		require.NoError(t, all.Err())
+
+		col = open(t)
+		_, err = col.Insert(&testData{Foo: "e"})
+		require.NoError(t, err)
+
+		stream, err := col.Watch(ctx, nil, WatchAfter(3))
+		require.NoError(t, err)
+		defer stream.Close()
+
+		require.Equal(t, event{seq: 4, op: ChangeDelete, id: 2, foo: "b"}, next(t, stream))
+		require.Equal(t, event{seq: 5, op: ChangeInsert, id: 3, foo: "d"}, next(t, stream))
+		require.Equal(t, event{seq: 6, op: ChangeInsert, id: 4, foo: "e"}, next(t, stream))
+	})
+
+	t.Run("collections without a change log can't be watched", func(t *testing.T) {
+		plain, err := NewFlatDBCollection[testData](db, "plain-collection", logger)
+		require.NoError(t, err)
+
+		_, err = plain.Watch(ctx, nil)
+		require.Error(t, err)
+	})
+}
+
+func TestChangeLogRecovery(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	db, err := NewFlatDB(dir, logger)
+	require.NoError(t, err)
+
+	open := func(t *testing.T) *FlatDBCollection[testData] {
+		col, err := NewFlatDBCollection[testData](db, "test-collection", logger, WithChangeLog[testData]())
+		require.NoError(t, err)
+		return col
+	}
+
+	events := func(t *testing.T, col *FlatDBCollection[testData]) []string {
+		stream, err := col.Watch(context.Background(), nil, WatchAfter(0))
+		require.NoError(t, err)
+		defer stream.Close()
+
+		res := []string{}
+		for seq := uint64(1); seq <= col.changeLog.lastSeq; seq++ {
+			require.True(t, stream.Next(), stream.Err())
+			e := stream.Event()
+			require.Equal(t, seq, e.Seq)
+			res = append(res, fmt.Sprintf("%s %d %s", e.Op, e.ID, e.Doc.Data.Foo))
+		}
+		return res
+	}
+
+	encode := func(t *testing.T, id uint64, foo string) []byte {
+		data, err := json.Marshal(FlatDBModel[testData]{Data: testData{Foo: foo}, ID: id})
+		require.NoError(t, err)
+		return data
+	}
+
+	col := open(t)
+	_, err = col.Insert(&testData{Foo: "a"})
+	require.NoError(t, err)
+	_, err = col.Insert(&testData{Foo: "b"})
+	require.NoError(t, err)
+	require.NoError(t, col.Close())
+
+	t.Run("writes replayed from the write-ahead log", func(t *testing.T) {
+		// simulate a crash after the intents were logged, the first of them was applied and recorded already
+		wal, err := openWriteAheadLog(filepath.Join(dir, "test-collection", walFileName), DurabilityNone)
+		require.NoError(t, err)
+		require.NoError(t, wal.append(walRecord{Op: walOpInsert, ID: 2, Data: encode(t, 2, "b"), Seq: 2}))
+		require.NoError(t, wal.append(walRecord{Op: walOpUpdate, ID: 1, Data: encode(t, 1, "c"), Seq: 3}))
+		require.NoError(t, wal.append(walRecord{Op: walOpDelete, ID: 2, Seq: 4, Old: encode(t, 2, "b")}))
+		require.NoError(t, wal.Close())
+
+		col := open(t)
+		require.Equal(t, []string{"insert 1 a", "insert 2 b", "update 1 c", "delete 2 b"}, events(t, col))
+		require.NoError(t, col.Close())
+	})
+
+	t.Run("writes of an interrupted transaction", func(t *testing.T) {
+		require.NoError(t, writeTxLog(db.dir, []txLogRecord{
+			{Collection: "test-collection", FileName: documentFileName(3, JSONCodec), walRecord: walRecord{Op: walOpInsert, ID: 3, Data: encode(t, 3, "d"), Seq: 5}},
+			{Collection: "test-collection", FileName: documentFileName(1, JSONCodec), walRecord: walRecord{Op: walOpDelete, ID: 1, Seq: 6, Old: encode(t, 1, "c")}},
+		}))
+
+		db, err = NewFlatDB(dir, logger)
+		require.NoError(t, err)
+
+		col := open(t)
+		require.Equal(t, []string{"insert 1 a", "insert 2 b", "update 1 c", "delete 2 b", "insert 3 d", "delete 1 c"}, events(t, col))
+
+		// new events follow the recovered ones
+		_, err = col.Insert(&testData{Foo: "e"})
+		require.NoError(t, err)
+		require.Equal(t, uint64(7), col.changeLog.lastSeq)
+		require.NoError(t, col.Close())
+	})
+}
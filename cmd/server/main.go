@@ -1 +1,77 @@
 package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/OlegStotsky/goflatdb"
+)
+
+func main() {
+	dirF := flag.String("dir", "data", "directory of the database")
+	addrF := flag.String("addr", ":8080", "address to listen on")
+	shutdownTimeoutF := flag.Duration("shutdown-timeout", 10*time.Second, "how long to wait for in-flight requests on shutdown")
+
+	flag.Parse()
+
+	logger, err := zap.NewProduction()
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		_ = logger.Sync()
+	}()
+
+	if err := os.MkdirAll(*dirF, 0777); err != nil {
+		logger.Fatal("error creating database directory", zap.Error(err))
+	}
+
+	db, err := goflatdb.NewFlatDB(*dirF, logger)
+	if err != nil {
+		logger.Fatal("error opening database", zap.Error(err))
+	}
+
+	srv := newServer(db, logger)
+	httpSrv := &http.Server{
+		Addr:              *addrF,
+		Handler:           srv,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("listening", zap.String("addr", *addrF), zap.String("dir", *dirF))
+		serveErr <- httpSrv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("error serving http", zap.Error(err))
+		}
+	case <-ctx.Done():
+		logger.Info("shutting down")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeoutF)
+		defer cancel()
+
+		if err := httpSrv.Shutdown(shutdownCtx); err != nil {
+			logger.Error("error shutting down http server", zap.Error(err))
+		}
+	}
+
+	if err := srv.Close(); err != nil {
+		logger.Error("error closing collections", zap.Error(err))
+	}
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/OlegStotsky/goflatdb"
+)
+
+// document is the type of documents served over http, collections are schemaless.
+type document = map[string]interface{}
+
+const maxBodySize = 10 << 20
+
+var collectionNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+var errNotFound = errors.New("not found")
+
+// server exposes the collections of a FlatDB over http:
+//
+//	POST   /collections/{name}/docs       inserts the document in the body
+//	GET    /collections/{name}/docs/{id}  returns a document
+//	PUT    /collections/{name}/docs/{id}  replaces a document with the body
+//	PATCH  /collections/{name}/docs/{id}  applies the JSON merge patch in the body to a document
+//	DELETE /collections/{name}/docs/{id}  deletes a document
+//	POST   /collections/{name}/query      runs the queryRequest in the body
+//
+// Collections are created by their first insert, the other requests answer 404 for collections that don't exist.
+type server struct {
+	db     *goflatdb.FlatDB
+	logger *zap.Logger
+
+	mu          sync.Mutex
+	collections map[string]*goflatdb.FlatDBCollection[document]
+}
+
+func newServer(db *goflatdb.FlatDB, logger *zap.Logger) *server {
+	return &server{
+		db:          db,
+		logger:      logger,
+		collections: map[string]*goflatdb.FlatDBCollection[document]{},
+	}
+}
+
+// queryRequest is the body of a query request. Filter is optional, without it every document matches.
+type queryRequest struct {
+	Filter  *queryFilter    `json:"filter"`
+	OrderBy []queryOrderKey `json:"orderBy"`
+	Offset  int             `json:"offset"`
+	Limit   *int            `json:"limit"`
+}
+
+// queryFilter is either a condition on a single field or a conjunction or disjunction of filters:
+//
+//	{"field": "age", "op": ">=", "value": 18}
+//	{"and": [{"field": "age", "op": ">=", "value": 18}, {"field": "name", "op": "prefix", "value": "A"}]}
+//	{"or": [...]}
+type queryFilter struct {
+	Field string        `json:"field"`
+	Op    string        `json:"op"`
+	Value interface{}   `json:"value"`
+	And   []queryFilter `json:"and"`
+	Or    []queryFilter `json:"or"`
+}
+
+type queryOrderKey struct {
+	Field     string `json:"field"`
+	Direction string `json:"direction"`
+}
+
+type queryResponse struct {
+	Docs []goflatdb.FlatDBModel[document] `json:"docs"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 3 || parts[0] != "collections" || !collectionNameRegexp.MatchString(parts[1]) {
+		s.writeError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+
+	name := parts[1]
+
+	switch {
+	case len(parts) == 3 && parts[2] == "docs":
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+		s.handleInsert(w, r, name)
+	case len(parts) == 4 && parts[2] == "docs":
+		id, err := strconv.ParseUint(parts[3], 10, 64)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid document id %q", parts[3]))
+			return
+		}
+
+		if !allowMethods(w, r, http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodDelete) {
+			return
+		}
+		s.handleDocument(w, r, name, id)
+	case len(parts) == 3 && parts[2] == "query":
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+		s.handleQuery(w, r, name)
+	default:
+		s.writeError(w, http.StatusNotFound, errNotFound)
+	}
+}
+
+func (s *server) handleInsert(w http.ResponseWriter, r *http.Request, name string) {
+	var doc document
+	if err := decodeDocument(r, &doc); err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	col, err := s.collection(name, true)
+	if err != nil {
+		s.writeError(w, statusForError(err), err)
+		return
+	}
+
+	res, err := col.InsertContext(r.Context(), &doc)
+	if err != nil {
+		s.writeError(w, statusForError(err), err)
+		return
+	}
+
+	// the document is read back for the metadata set by the collection
+	model, err := col.GetByIDContext(r.Context(), res.ID)
+	if err != nil {
+		s.writeError(w, statusForError(err), err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusCreated, model)
+}
+
+func (s *server) handleDocument(w http.ResponseWriter, r *http.Request, name string, id uint64) {
+	col, err := s.collection(name, false)
+	if err != nil {
+		s.writeError(w, statusForError(err), err)
+		return
+	}
+
+	var res goflatdb.FlatDBModel[document]
+
+	switch r.Method {
+	case http.MethodGet:
+		res, err = col.GetByIDContext(r.Context(), id)
+	case http.MethodPut:
+		var doc document
+		if err := decodeDocument(r, &doc); err != nil {
+			s.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// If-Match carries the version the client has read, the update is rejected if the document has moved on
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+			version, parseErr := strconv.ParseUint(strings.Trim(ifMatch, `"`), 10, 64)
+			if parseErr != nil {
+				s.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid If-Match header %q", ifMatch))
+				return
+			}
+
+			err = col.UpdateIfVersionContext(r.Context(), id, version, &doc)
+		} else {
+			err = col.UpdateContext(r.Context(), id, &doc)
+		}
+		if err == nil {
+			res, err = col.GetByIDContext(r.Context(), id)
+		}
+	case http.MethodPatch:
+		var patch json.RawMessage
+		if err := decodePatch(r, &patch); err != nil {
+			s.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		res, err = col.PatchContext(r.Context(), id, patch)
+	case http.MethodDelete:
+		if err := col.DeleteContext(r.Context(), id); err != nil {
+			s.writeError(w, statusForError(err), err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err != nil {
+		s.writeError(w, statusForError(err), err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, res)
+}
+
+func (s *server) handleQuery(w http.ResponseWriter, r *http.Request, name string) {
+	var req queryRequest
+	if err := decodeBody(r, &req); err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	col, err := s.collection(name, false)
+	if err != nil {
+		s.writeError(w, statusForError(err), err)
+		return
+	}
+
+	q, err := buildQuery(col, req)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	docs, err := q.ExecuteContext(r.Context())
+	if err != nil {
+		s.writeError(w, statusForError(err), err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, queryResponse{Docs: docs})
+}
+
+// buildQuery maps req onto a QueryBuilder of col.
+func buildQuery(col *goflatdb.FlatDBCollection[document], req queryRequest) (*goflatdb.QueryBuilder[document], error) {
+	q := col.QueryBuilder().Select()
+	if req.Filter != nil {
+		var err error
+		if q, err = buildFilter(col, *req.Filter); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, key := range req.OrderBy {
+		var direction goflatdb.SortDirection
+		switch strings.ToLower(key.Direction) {
+		case "", "asc":
+			direction = goflatdb.Asc
+		case "desc":
+			direction = goflatdb.Desc
+		default:
+			return nil, fmt.Errorf("invalid sort direction %q", key.Direction)
+		}
+
+		q = q.OrderBy(key.Field, direction)
+	}
+
+	if req.Offset < 0 {
+		return nil, fmt.Errorf("invalid offset %d", req.Offset)
+	}
+	if req.Offset > 0 {
+		q = q.Offset(req.Offset)
+	}
+
+	if req.Limit != nil {
+		if *req.Limit < 0 {
+			return nil, fmt.Errorf("invalid limit %d", *req.Limit)
+		}
+		q = q.Limit(*req.Limit)
+	}
+
+	return q, nil
+}
+
+func buildFilter(col *goflatdb.FlatDBCollection[document], f queryFilter) (*goflatdb.QueryBuilder[document], error) {
+	set := 0
+	if f.Field != "" {
+		set++
+	}
+	if f.And != nil {
+		set++
+	}
+	if f.Or != nil {
+		set++
+	}
+	if set != 1 {
+		return nil, errors.New("filter must have exactly one of field, and, or")
+	}
+
+	if f.Field != "" {
+		return col.QueryBuilder().Where(f.Field, f.Op, f.Value), nil
+	}
+
+	filters, combine := f.And, (*goflatdb.QueryBuilder[document]).And
+	if f.Or != nil {
+		filters, combine = f.Or, (*goflatdb.QueryBuilder[document]).Or
+	}
+
+	if len(filters) == 0 {
+		return nil, errors.New("and/or filter must not be empty")
+	}
+
+	q, err := buildFilter(col, filters[0])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, filter := range filters[1:] {
+		other, err := buildFilter(col, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		q = combine(q, other)
+	}
+
+	return q, nil
+}
+
+// collection returns the collection name, opening it on first use. Unless create is set, it returns errNotFound
+// rather than creating a collection that doesn't exist yet.
+func (s *server) collection(name string, create bool) (*goflatdb.FlatDBCollection[document], error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if col, ok := s.collections[name]; ok {
+		return col, nil
+	}
+
+	if !create {
+		exists, err := s.db.HasCollection(name)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("collection %s: %w", name, errNotFound)
+		}
+	}
+
+	col, err := goflatdb.NewFlatDBCollection[document](s.db, name, s.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	s.collections[name] = col
+
+	return col, nil
+}
+
+// Close closes all collections opened by s.
+func (s *server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	errs := make([]error, 0, len(s.collections))
+	for name, col := range s.collections {
+		errs = append(errs, col.Close())
+		delete(s.collections, name)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *server) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.Error("error writing response", zap.Error(err))
+	}
+}
+
+func (s *server) writeError(w http.ResponseWriter, status int, err error) {
+	if status >= http.StatusInternalServerError {
+		s.logger.Error("error handling request", zap.Error(err))
+	}
+
+	s.writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, goflatdb.DocumentNotFound), errors.Is(err, errNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, goflatdb.InvalidQuery):
+		return http.StatusBadRequest
+	case errors.As(err, new(*goflatdb.ErrVersionConflict)):
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusMethodNotAllowed)
+	_ = json.NewEncoder(w).Encode(errorResponse{Error: fmt.Sprintf("method %s not allowed", r.Method)})
+
+	return false
+}
+
+func decodeBody(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxBodySize))
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("error decoding request body: %w", err)
+	}
+
+	return nil
+}
+
+func decodeDocument(r *http.Request, doc *document) error {
+	if err := decodeBody(r, doc); err != nil {
+		return err
+	}
+
+	if *doc == nil {
+		return errors.New("document must be a JSON object")
+	}
+
+	return nil
+}
+
+// decodePatch decodes a JSON merge patch, which must be an object: any other value would replace the whole document.
+func decodePatch(r *http.Request, patch *json.RawMessage) error {
+	if err := decodeBody(r, patch); err != nil {
+		return err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(*patch, &fields); err != nil || fields == nil {
+		return errors.New("patch must be a JSON object")
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/OlegStotsky/goflatdb"
+)
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	db, err := goflatdb.NewFlatDB(dir, logger)
+	require.NoError(t, err)
+
+	srv := newServer(db, logger)
+	defer func() {
+		require.NoError(t, srv.Close())
+	}()
+
+	do := func(t *testing.T, method string, path string, body string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader([]byte(body))))
+
+		res := map[string]interface{}{}
+		if rec.Body.Len() > 0 {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		}
+
+		return rec.Code, res
+	}
+
+	t.Run("crud", func(t *testing.T) {
+		code, res := do(t, http.MethodPost, "/collections/users/docs", `{"name": "alice", "age": 30}`)
+		require.Equal(t, http.StatusCreated, code)
+		require.Equal(t, float64(1), res["ID"])
+
+		code, res = do(t, http.MethodGet, "/collections/users/docs/1", "")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, map[string]interface{}{"name": "alice", "age": float64(30)}, res["data"])
+
+		code, _ = do(t, http.MethodPut, "/collections/users/docs/1", `{"name": "alice", "age": 31}`)
+		require.Equal(t, http.StatusOK, code)
+
+		code, res = do(t, http.MethodPatch, "/collections/users/docs/1", `{"age": null, "city": "paris"}`)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, map[string]interface{}{"name": "alice", "city": "paris"}, res["data"])
+
+		for _, patch := range []string{`[1]`, `5`, `null`, `"x"`} {
+			code, _ = do(t, http.MethodPatch, "/collections/users/docs/1", patch)
+			require.Equal(t, http.StatusBadRequest, code, patch)
+		}
+
+		code, res = do(t, http.MethodGet, "/collections/users/docs/1", "")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, map[string]interface{}{"name": "alice", "city": "paris"}, res["data"])
+
+		code, _ = do(t, http.MethodDelete, "/collections/users/docs/1", "")
+		require.Equal(t, http.StatusNoContent, code)
+
+		code, _ = do(t, http.MethodGet, "/collections/users/docs/1", "")
+		require.Equal(t, http.StatusNotFound, code)
+
+		code, _ = do(t, http.MethodPut, "/collections/users/docs/1", `{"name": "bob"}`)
+		require.Equal(t, http.StatusNotFound, code)
+
+		code, _ = do(t, http.MethodDelete, "/collections/users/docs/1", "")
+		require.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("versions", func(t *testing.T) {
+		code, res := do(t, http.MethodPost, "/collections/versions/docs", `{"name": "alice"}`)
+		require.Equal(t, http.StatusCreated, code)
+		require.Equal(t, float64(1), res["version"])
+
+		put := func(ifMatch string, body string) int {
+			req := httptest.NewRequest(http.MethodPut, "/collections/versions/docs/1", bytes.NewReader([]byte(body)))
+			req.Header.Set("If-Match", ifMatch)
+
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		require.Equal(t, http.StatusOK, put(`"1"`, `{"name": "bob"}`))
+		require.Equal(t, http.StatusPreconditionFailed, put(`"1"`, `{"name": "carol"}`))
+		require.Equal(t, http.StatusBadRequest, put(`"x"`, `{"name": "carol"}`))
+
+		code, res = do(t, http.MethodGet, "/collections/versions/docs/1", "")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, float64(2), res["version"])
+		require.Equal(t, map[string]interface{}{"name": "bob"}, res["data"])
+	})
+
+	t.Run("query", func(t *testing.T) {
+		for _, doc := range []string{
+			`{"name": "a", "age": 10}`,
+			`{"name": "b", "age": 20}`,
+			`{"name": "c", "age": 30}`,
+			`{"name": "d", "age": 40}`,
+		} {
+			code, _ := do(t, http.MethodPost, "/collections/people/docs", doc)
+			require.Equal(t, http.StatusCreated, code)
+		}
+
+		names := func(res map[string]interface{}) []string {
+			names := []string{}
+			for _, doc := range res["docs"].([]interface{}) {
+				names = append(names, doc.(map[string]interface{})["data"].(map[string]interface{})["name"].(string))
+			}
+			return names
+		}
+
+		code, res := do(t, http.MethodPost, "/collections/people/query", `{}`)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, []string{"a", "b", "c", "d"}, names(res))
+
+		code, res = do(t, http.MethodPost, "/collections/people/query", `{
+			"filter": {"or": [
+				{"and": [{"field": "age", "op": ">", "value": 10}, {"field": "age", "op": "<", "value": 40}]},
+				{"field": "name", "op": "=", "value": "a"}
+			]},
+			"orderBy": [{"field": "age", "direction": "desc"}],
+			"offset": 1,
+			"limit": 2
+		}`)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, []string{"b", "a"}, names(res))
+
+		code, _ = do(t, http.MethodPost, "/collections/people/query", `{"filter": {"field": "age", "op": "~", "value": 1}}`)
+		require.Equal(t, http.StatusBadRequest, code)
+
+		code, _ = do(t, http.MethodPost, "/collections/people/query", `{"filter": {"and": []}}`)
+		require.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("reads don't create collections", func(t *testing.T) {
+		code, _ := do(t, http.MethodGet, "/collections/missing/docs/1", "")
+		require.Equal(t, http.StatusNotFound, code)
+
+		code, _ = do(t, http.MethodDelete, "/collections/missing/docs/1", "")
+		require.Equal(t, http.StatusNotFound, code)
+
+		code, _ = do(t, http.MethodPost, "/collections/missing/query", `{}`)
+		require.Equal(t, http.StatusNotFound, code)
+
+		_, err := os.Stat(filepath.Join(dir, "missing"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		// collections created before the server opened them are served
+		col, err := goflatdb.NewFlatDBCollection[document](db, "existing", logger)
+		require.NoError(t, err)
+		_, err = col.Insert(&document{"name": "alice"})
+		require.NoError(t, err)
+		require.NoError(t, col.Close())
+
+		code, res := do(t, http.MethodGet, "/collections/existing/docs/1", "")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, map[string]interface{}{"name": "alice"}, res["data"])
+	})
+
+	t.Run("bad requests", func(t *testing.T) {
+		code, _ := do(t, http.MethodPost, "/collections/users/docs", `[1, 2]`)
+		require.Equal(t, http.StatusBadRequest, code)
+
+		code, _ = do(t, http.MethodGet, "/collections/users/docs/abc", "")
+		require.Equal(t, http.StatusBadRequest, code)
+
+		code, _ = do(t, http.MethodGet, "/collections/users/docs", "")
+		require.Equal(t, http.StatusMethodNotAllowed, code)
+
+		code, _ = do(t, http.MethodGet, "/collections/../docs/1", "")
+		require.Equal(t, http.StatusNotFound, code)
+
+		code, _ = do(t, http.MethodGet, "/unknown", "")
+		require.Equal(t, http.StatusNotFound, code)
+	})
+}
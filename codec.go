@@ -0,0 +1,192 @@
+package goflatdb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec encodes the documents of a collection in their files. Struct fields of documents are named
+// by their json tags in every codec except GobCodec, which ignores tags and uses the Go field names.
+type Codec interface {
+	// Name identifies the codec in the metadata of collections.
+	Name() string
+	// Extension is the file extension of documents, including the dot.
+	Extension() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSONCodec stores documents as JSON. It is the default codec.
+	JSONCodec Codec = jsonCodec{}
+	// MessagePackCodec stores documents as MessagePack.
+	MessagePackCodec Codec = msgpackCodec{}
+	// CBORCodec stores documents as CBOR (RFC 8949).
+	CBORCodec Codec = cborCodec{}
+	// GobCodec stores documents with encoding/gob. Collections using it can't have migrations, as gob
+	// can't decode structs into maps.
+	GobCodec Codec = gobCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Extension() string {
+	return ".json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (msgpackCodec) Extension() string {
+	return ".msgpack"
+}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	e := msgpack.NewEncoder(&b)
+	e.SetCustomStructTag("json")
+	if err := e.Encode(v); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	d := msgpack.NewDecoder(bytes.NewReader(data))
+	d.SetCustomStructTag("json")
+	d.SetMapDecoder(func(d *msgpack.Decoder) (interface{}, error) {
+		return d.DecodeUntypedMap()
+	})
+
+	return d.Decode(v)
+}
+
+type cborCodec struct{}
+
+// cborEncMode keeps the nanoseconds of times, which are encoded as seconds by default.
+var cborEncMode, _ = cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+
+func (cborCodec) Name() string {
+	return "cbor"
+}
+
+func (cborCodec) Extension() string {
+	return ".cbor"
+}
+
+func (cborCodec) Marshal(v interface{}) ([]byte, error) {
+	return cborEncMode.Marshal(v)
+}
+
+func (cborCodec) Unmarshal(data []byte, v interface{}) error {
+	return cbor.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string {
+	return "gob"
+}
+
+func (gobCodec) Extension() string {
+	return ".gob"
+}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// ErrCodecMismatch is returned when a collection is opened with another codec than the one its documents are stored with.
+var ErrCodecMismatch = errors.New("codec mismatch")
+
+const collectionMetaFileName = "collection.meta"
+
+// collectionMeta describes how the files of a collection are stored.
+type collectionMeta struct {
+	Codec string `json:"codec"`
+}
+
+// checkCodec records the codec of the collection in its metadata, or returns ErrCodecMismatch if the collection
+// is stored with another one. Collections created before codecs were recorded are stored as JSON.
+func (c *FlatDBCollection[T]) checkCodec() error {
+	path := filepath.Join(c.dir.Name(), collectionMetaFileName)
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		stored := collectionMeta{}
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return errorCheckingCodec(err)
+		}
+
+		return c.matchCodec(stored.Codec)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return errorCheckingCodec(err)
+	}
+
+	legacy, err := filepath.Glob(filepath.Join(c.dir.Name(), "*"+JSONCodec.Extension()))
+	if err != nil {
+		return errorCheckingCodec(err)
+	}
+	if len(legacy) > 0 {
+		if err := c.matchCodec(JSONCodec.Name()); err != nil {
+			return err
+		}
+	}
+
+	data, err = json.Marshal(collectionMeta{Codec: c.codec.Name()})
+	if err != nil {
+		return errorCheckingCodec(err)
+	}
+
+	if err := writeFileAtomic(c.dir, collectionMetaFileName, data, c.durability); err != nil {
+		return errorCheckingCodec(err)
+	}
+
+	return nil
+}
+
+func (c *FlatDBCollection[T]) matchCodec(stored string) error {
+	if stored != c.codec.Name() {
+		return fmt.Errorf("%w: collection is stored with codec %s, not %s", ErrCodecMismatch, stored, c.codec.Name())
+	}
+
+	return nil
+}
+
+func errorCheckingCodec(err error) error {
+	return fmt.Errorf("error checking codec: %w", err)
+}
@@ -0,0 +1,129 @@
+package goflatdb
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type codecTestData struct {
+	Name  string            `json:"name"`
+	Count int               `json:"count"`
+	Tags  []string          `json:"tags"`
+	Attrs map[string]string `json:"attrs"`
+}
+
+func TestCodecs(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec, MessagePackCodec, CBORCodec, GobCodec} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			dir := t.TempDir()
+
+			logger, err := zap.NewDevelopment()
+			require.NoError(t, err)
+
+			db, err := NewFlatDB(dir, logger)
+			require.NoError(t, err)
+
+			open := func(t *testing.T) *FlatDBCollection[codecTestData] {
+				col, err := NewFlatDBCollection[codecTestData](db, "test-collection", logger,
+					WithCodec[codecTestData](codec),
+					WithOrderedIndex[codecTestData]("count"),
+				)
+				require.NoError(t, err)
+				return col
+			}
+
+			col := open(t)
+
+			res, err := col.Insert(&codecTestData{Name: "a", Count: 1, Tags: []string{"x"}, Attrs: map[string]string{"k": "v"}})
+			require.NoError(t, err)
+			_, err = col.Insert(&codecTestData{Name: "b", Count: 2})
+			require.NoError(t, err)
+
+			require.FileExists(t, filepath.Join(dir, "test-collection", "1"+codec.Extension()))
+
+			require.NoError(t, col.Update(res.ID, &codecTestData{Name: "a", Count: 3, Tags: []string{"x", "y"}, Attrs: map[string]string{"k": "v"}}))
+			_, err = col.Patch(res.ID, []byte(`{"name": "c"}`))
+			require.NoError(t, err)
+
+			tx := db.Begin()
+			_, err = col.InsertTx(tx, &codecTestData{Name: "d", Count: 4})
+			require.NoError(t, err)
+			require.NoError(t, tx.Commit())
+			require.NoError(t, col.Close())
+
+			// the documents are indexed again from their files
+			col = open(t)
+
+			doc, err := col.GetByID(res.ID)
+			require.NoError(t, err)
+			require.Equal(t, codecTestData{Name: "c", Count: 3, Tags: []string{"x", "y"}, Attrs: map[string]string{"k": "v"}}, doc.Data)
+			require.Equal(t, uint64(3), doc.Version)
+			require.NotZero(t, doc.Checksum)
+			require.False(t, doc.CreatedAt.IsZero())
+
+			found, err := col.QueryBuilder().Where("count", ">", 1).OrderBy("count", Asc).Execute()
+			require.NoError(t, err)
+			require.Len(t, found, 3)
+			require.Equal(t, []string{"b", "c", "d"}, []string{found[0].Data.Name, found[1].Data.Name, found[2].Data.Name})
+
+			require.NoError(t, col.Close())
+
+			for _, other := range []Codec{JSONCodec, MessagePackCodec, CBORCodec, GobCodec} {
+				if other == codec {
+					continue
+				}
+
+				_, err := NewFlatDBCollection[codecTestData](db, "test-collection", logger, WithCodec[codecTestData](other))
+				require.ErrorIs(t, err, ErrCodecMismatch)
+			}
+		})
+	}
+
+	t.Run("migrations", func(t *testing.T) {
+		for _, codec := range []Codec{MessagePackCodec, CBORCodec} {
+			dir := t.TempDir()
+
+			logger, err := zap.NewDevelopment()
+			require.NoError(t, err)
+
+			db, err := NewFlatDB(dir, logger)
+			require.NoError(t, err)
+
+			old, err := NewFlatDBCollection[migrateTestDataV1](db, "test-collection", logger, WithCodec[migrateTestDataV1](codec))
+			require.NoError(t, err)
+			res, err := old.Insert(&migrateTestDataV1{Name: "Ada Lovelace", Age: 36})
+			require.NoError(t, err)
+			require.NoError(t, old.Close())
+
+			col, err := NewFlatDBCollection[migrateTestData](db, "test-collection", logger,
+				WithCodec[migrateTestData](codec),
+				WithMigration[migrateTestData](2, func(data map[string]interface{}) error {
+					data["firstName"] = data["name"]
+					return nil
+				}),
+			)
+			require.NoError(t, err)
+
+			doc, err := col.GetByID(res.ID)
+			require.NoError(t, err, codec.Name())
+			require.Equal(t, migrateTestData{FirstName: "Ada Lovelace", Age: 36}, doc.Data, codec.Name())
+		}
+
+		logger, err := zap.NewDevelopment()
+		require.NoError(t, err)
+
+		db, err := NewFlatDB(t.TempDir(), logger)
+		require.NoError(t, err)
+
+		_, err = NewFlatDBCollection[migrateTestData](db, "test-collection", logger,
+			WithCodec[migrateTestData](GobCodec),
+			WithMigration[migrateTestData](2, func(data map[string]interface{}) error {
+				return nil
+			}),
+		)
+		require.Error(t, err)
+	})
+}
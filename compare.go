@@ -0,0 +1,182 @@
+package goflatdb
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// normalizeValue converts v to a canonical representation, so that values that are equal for queries
+// are also equal as index map keys: integers become int64 (uint64 if they overflow it), floats with an integral
+// value become integers and the rest float64, named string and bool types become string and bool,
+// times are converted to UTC and nil pointers become nil. Other values are returned as is.
+func normalizeValue(v interface{}) interface{} {
+	refVal := reflect.ValueOf(v)
+	if !refVal.IsValid() || (refVal.Kind() == reflect.Pointer && refVal.IsNil()) {
+		return nil
+	}
+
+	if refVal.Type() == timeType {
+		return refVal.Interface().(time.Time).UTC()
+	}
+
+	switch {
+	case isIntKind(refVal.Kind()):
+		return refVal.Int()
+	case isUintKind(refVal.Kind()):
+		n := refVal.Uint()
+		if n > math.MaxInt64 {
+			return n
+		}
+		return int64(n)
+	case isFloatKind(refVal.Kind()):
+		f := refVal.Float()
+		if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+			return int64(f)
+		}
+		if f == math.Trunc(f) && f >= math.MaxInt64 && f < math.MaxUint64 {
+			return uint64(f)
+		}
+		return f
+	case refVal.Kind() == reflect.String:
+		return refVal.String()
+	case refVal.Kind() == reflect.Bool:
+		return refVal.Bool()
+	}
+
+	return v
+}
+
+// compareValues compares a and b, returning -1, 0 or 1.
+// All integer and float kinds are compared numerically with each other, strings, bools and time.Time
+// only with values of the same kind.
+func compareValues(a interface{}, b interface{}) (int, error) {
+	switch aVal := normalizeValue(a).(type) {
+	case int64:
+		switch bVal := normalizeValue(b).(type) {
+		case int64:
+			return compareOrdered(aVal, bVal), nil
+		case uint64:
+			// normalized uint64 values are always above math.MaxInt64
+			return -1, nil
+		case float64:
+			return compareOrdered(float64(aVal), bVal), nil
+		}
+	case uint64:
+		switch bVal := normalizeValue(b).(type) {
+		case int64:
+			return 1, nil
+		case uint64:
+			return compareOrdered(aVal, bVal), nil
+		case float64:
+			return compareOrdered(float64(aVal), bVal), nil
+		}
+	case float64:
+		switch bVal := normalizeValue(b).(type) {
+		case int64:
+			return compareOrdered(aVal, float64(bVal)), nil
+		case uint64:
+			return compareOrdered(aVal, float64(bVal)), nil
+		case float64:
+			return compareOrdered(aVal, bVal), nil
+		}
+	case string:
+		if bVal, ok := normalizeValue(b).(string); ok {
+			return strings.Compare(aVal, bVal), nil
+		}
+	case bool:
+		if bVal, ok := normalizeValue(b).(bool); ok {
+			return compareBools(aVal, bVal), nil
+		}
+	case time.Time:
+		if bVal, ok := normalizeValue(b).(time.Time); ok {
+			return aVal.Compare(bVal), nil
+		}
+	}
+
+	return 0, errorComparingValues(a, b)
+}
+
+// compareIndexKeys is a total order over index keys: composite keys are ordered lexicographically,
+// comparable keys by compareValues, the rest by their kind and then by their string representation.
+func compareIndexKeys(a interface{}, b interface{}) int {
+	if aKey, ok := a.(compositeKey); ok {
+		if bKey, ok := b.(compositeKey); ok {
+			return compareCompositeKeys(aKey, bKey)
+		}
+	}
+
+	if res, err := compareValues(a, b); err == nil {
+		return res
+	}
+
+	if res := compareOrdered(kindRank(a), kindRank(b)); res != 0 {
+		return res
+	}
+
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func kindRank(v interface{}) int {
+	refVal := reflect.ValueOf(v)
+	switch {
+	case !refVal.IsValid():
+		return 0
+	case refVal.Kind() == reflect.Bool:
+		return 1
+	case isIntKind(refVal.Kind()) || isUintKind(refVal.Kind()) || isFloatKind(refVal.Kind()):
+		return 2
+	case refVal.Kind() == reflect.String:
+		return 3
+	case refVal.Type() == timeType:
+		return 4
+	default:
+		return 5
+	}
+}
+
+type ordered interface {
+	~int | ~int64 | ~uint64 | ~float64 | ~string
+}
+
+func compareOrdered[V ordered](a V, b V) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareBools(a bool, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case !a:
+		return -1
+	default:
+		return 1
+	}
+}
+
+func isIntKind(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func isUintKind(k reflect.Kind) bool {
+	return k >= reflect.Uint && k <= reflect.Uintptr
+}
+
+func isFloatKind(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+func errorComparingValues(a interface{}, b interface{}) error {
+	return fmt.Errorf("%w: error comparing values %v (%T) and %v (%T)", InvalidQuery, a, a, b, b)
+}
@@ -0,0 +1,152 @@
+package goflatdb
+
+import (
+	"sort"
+	"strings"
+)
+
+// compositeKey is the key of a composite index: the values of its fields in index order.
+// Composite keys are ordered lexicographically, so keys sharing a prefix are adjacent in the index.
+type compositeKey []interface{}
+
+type flatDBCompositeIndex struct {
+	fieldNames []string
+	paths      []fieldPath
+
+	data *skipList // key - compositeKey of fieldNames, val - fileNames, ordered by compareIndexKeys
+}
+
+func newFlatDBCompositeIndex(fieldNames []string) *flatDBCompositeIndex {
+	return &flatDBCompositeIndex{
+		fieldNames: fieldNames,
+		data:       newSkipList(),
+	}
+}
+
+func compositeIndexName(fieldNames []string) string {
+	return strings.Join(fieldNames, ",")
+}
+
+// keys returns the composite keys of data: one for every combination of the values its paths resolve to.
+// Missing values are stored as nil, so documents missing a trailing field are still found by lookups
+// on a shorter prefix.
+func (idx *flatDBCompositeIndex) keys(data interface{}) []compositeKey {
+	keys := []compositeKey{{}}
+	for _, path := range idx.paths {
+		values := indexKeys(data, path)
+		if len(values) == 0 {
+			values = []interface{}{nil}
+		}
+
+		next := make([]compositeKey, 0, len(keys)*len(values))
+		for _, key := range keys {
+			for _, value := range values {
+				next = append(next, append(key[:len(key):len(key)], value))
+			}
+		}
+
+		keys = next
+	}
+
+	return keys
+}
+
+func (idx *flatDBCompositeIndex) add(key compositeKey, fileName string) {
+	idx.data.add(key, fileName)
+}
+
+func (idx *flatDBCompositeIndex) remove(key compositeKey, fileName string) {
+	idx.data.remove(key, fileName)
+}
+
+// scanPrefix calls fn for every key starting with prefix in ascending order until fn returns false.
+func (idx *flatDBCompositeIndex) scanPrefix(prefix compositeKey, fn func(key compositeKey, fileNames []string) bool) {
+	for node := idx.data.seek(prefix, true); node != nil; node = node.next[0] {
+		key := node.key.(compositeKey)
+		if !key.hasPrefix(prefix) {
+			return
+		}
+
+		if !fn(key, node.fileNames) {
+			return
+		}
+	}
+}
+
+func (k compositeKey) hasPrefix(prefix compositeKey) bool {
+	if len(k) < len(prefix) {
+		return false
+	}
+
+	for i := range prefix {
+		if compareIndexKeys(k[i], prefix[i]) != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func compareCompositeKeys(a compositeKey, b compositeKey) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if res := compareIndexKeys(a[i], b[i]); res != 0 {
+			return res
+		}
+	}
+
+	return compareOrdered(len(a), len(b))
+}
+
+// lookupCompositeIndex returns the file names of the documents whose fields equal equalities on the longest
+// prefix of a composite index covered by equalities, sorted by id. ok is false if no composite index
+// starts with a field of equalities. Documents have to be matched against equalities again, as only
+// the prefix is looked up.
+func (c *FlatDBCollection[T]) lookupCompositeIndex(equalities map[string]interface{}) (fileNames []string, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	names := make([]string, 0, len(c.compositeIndexes))
+	for name := range c.compositeIndexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var best *flatDBCompositeIndex
+	var bestPrefix compositeKey
+	for _, name := range names {
+		idx := c.compositeIndexes[name]
+
+		prefix := compositeKey{}
+		for _, fieldName := range idx.fieldNames {
+			val, ok := equalities[fieldName]
+			if !ok {
+				break
+			}
+			prefix = append(prefix, normalizeValue(val))
+		}
+
+		if len(prefix) > len(bestPrefix) {
+			best, bestPrefix = idx, prefix
+		}
+	}
+
+	if best == nil {
+		return nil, false
+	}
+
+	fileNames = []string{}
+	seen := map[string]struct{}{}
+	best.scanPrefix(bestPrefix, func(_ compositeKey, names []string) bool {
+		for _, name := range names {
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			fileNames = append(fileNames, name)
+		}
+		return true
+	})
+	sortFileNamesByID(fileNames)
+
+	return fileNames, true
+}
@@ -0,0 +1,169 @@
+package goflatdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is an algorithm compressing document files, see WithCompression.
+type Compression uint8
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionZstd
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("Compression(%d)", uint8(c))
+	}
+}
+
+// compressionMagic starts compressed document files, followed by a byte naming the algorithm. Encoded documents
+// never start with a zero byte, so compressed and uncompressed files are told apart by their first bytes.
+const compressionMagic = "\x00FDC"
+
+// zstdDecoder decompresses zstd documents of all collections, DecodeAll may be called concurrently.
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+// compressor compresses the document files of a collection.
+type compressor struct {
+	compression Compression
+	level       int
+	minSize     int
+
+	zstdEncoder *zstd.Encoder
+}
+
+func newCompressor(compression Compression, level int, minSize int) (*compressor, error) {
+	c := &compressor{compression: compression, level: level, minSize: minSize}
+
+	switch compression {
+	case CompressionNone:
+	case CompressionGzip:
+		if level == 0 {
+			c.level = gzip.DefaultCompression
+		}
+
+		if _, err := gzip.NewWriterLevel(io.Discard, c.level); err != nil {
+			return nil, errorCreatingCompressor(compression, err)
+		}
+	case CompressionZstd:
+		zstdLevel := zstd.SpeedDefault
+		if level != 0 {
+			zstdLevel = zstd.EncoderLevelFromZstd(level)
+		}
+
+		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstdLevel))
+		if err != nil {
+			return nil, errorCreatingCompressor(compression, err)
+		}
+		c.zstdEncoder = encoder
+	default:
+		return nil, errorCreatingCompressor(compression, errors.New("unknown compression"))
+	}
+
+	return c, nil
+}
+
+// compress returns the contents of the file of a document encoded as data. Documents smaller than the minimum size,
+// or which don't get smaller, are stored uncompressed.
+func (c *compressor) compress(data []byte) ([]byte, error) {
+	if c.compression == CompressionNone || len(data) < c.minSize {
+		return data, nil
+	}
+
+	var b bytes.Buffer
+	b.WriteString(compressionMagic)
+	b.WriteByte(byte(c.compression))
+
+	switch c.compression {
+	case CompressionGzip:
+		w, err := gzip.NewWriterLevel(&b, c.level)
+		if err != nil {
+			return nil, errorCompressingDocument(c.compression, err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, errorCompressingDocument(c.compression, err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, errorCompressingDocument(c.compression, err)
+		}
+	case CompressionZstd:
+		b.Write(c.zstdEncoder.EncodeAll(data, nil))
+	}
+
+	if b.Len() >= len(data) {
+		return data, nil
+	}
+
+	return b.Bytes(), nil
+}
+
+func (c *compressor) Close() error {
+	if c.zstdEncoder != nil {
+		return c.zstdEncoder.Close()
+	}
+
+	return nil
+}
+
+// decompressDocument returns the encoded document stored in a document file, which may be compressed
+// by any algorithm regardless of the compression the collection currently uses.
+func decompressDocument(file []byte) ([]byte, error) {
+	if !bytes.HasPrefix(file, []byte(compressionMagic)) || len(file) <= len(compressionMagic) {
+		return file, nil
+	}
+
+	compression := Compression(file[len(compressionMagic)])
+	compressed := file[len(compressionMagic)+1:]
+
+	switch compression {
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, errorDecompressingDocument(compression, err)
+		}
+
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, errorDecompressingDocument(compression, err)
+		}
+
+		return data, nil
+	case CompressionZstd:
+		data, err := zstdDecoder.DecodeAll(compressed, nil)
+		if err != nil {
+			return nil, errorDecompressingDocument(compression, err)
+		}
+
+		return data, nil
+	default:
+		return nil, errorDecompressingDocument(compression, errors.New("unknown compression"))
+	}
+}
+
+func errorCreatingCompressor(compression Compression, err error) error {
+	return fmt.Errorf("error creating %s compressor: %w", compression, err)
+}
+
+func errorCompressingDocument(compression Compression, err error) error {
+	return fmt.Errorf("error compressing document with %s: %w", compression, err)
+}
+
+func errorDecompressingDocument(compression Compression, err error) error {
+	return fmt.Errorf("error decompressing document with %s: %w", compression, err)
+}
@@ -0,0 +1,89 @@
+package goflatdb
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCompression(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	db, err := NewFlatDB(dir, logger)
+	require.NoError(t, err)
+
+	open := func(t *testing.T, opts ...FlatDBCollectionOption[testData]) *FlatDBCollection[testData] {
+		opts = append(opts, WithUnorderedIndex[testData]("foo"))
+		col, err := NewFlatDBCollection[testData](db, "test-collection", logger, opts...)
+		require.NoError(t, err)
+		return col
+	}
+
+	readFile := func(t *testing.T, id uint64) []byte {
+		data, err := os.ReadFile(filepath.Join(dir, "test-collection", documentFileName(id, JSONCodec)))
+		require.NoError(t, err)
+		return data
+	}
+
+	large := strings.Repeat("compressible ", 100)
+
+	// documents written before compression is enabled stay uncompressed
+	col := open(t)
+	_, err = col.Insert(&testData{Foo: large + "plain"})
+	require.NoError(t, err)
+	require.NoError(t, col.Close())
+
+	col = open(t, WithCompression[testData](CompressionGzip, 9, 512))
+	_, err = col.Insert(&testData{Foo: large + "gzip"})
+	require.NoError(t, err)
+	_, err = col.Insert(&testData{Foo: "small"})
+	require.NoError(t, err)
+	require.NoError(t, col.Close())
+
+	col = open(t, WithCompression[testData](CompressionZstd, 0, 512))
+	_, err = col.Insert(&testData{Foo: large + "zstd"})
+	require.NoError(t, err)
+
+	require.Equal(t, byte('{'), readFile(t, 1)[0])
+	require.Equal(t, compressionMagic+"\x01", string(readFile(t, 2)[:5]))
+	require.Equal(t, byte('{'), readFile(t, 3)[0])
+	require.Equal(t, compressionMagic+"\x02", string(readFile(t, 4)[:5]))
+	require.Less(t, len(readFile(t, 4)), len(large))
+
+	for id, foo := range map[uint64]string{1: large + "plain", 2: large + "gzip", 3: "small", 4: large + "zstd"} {
+		doc, err := col.GetByID(id)
+		require.NoError(t, err)
+		require.Equal(t, foo, doc.Data.Foo)
+	}
+
+	t.Run("writes compress documents", func(t *testing.T) {
+		require.NoError(t, col.Update(1, &testData{Foo: large + "updated"}))
+		require.Equal(t, compressionMagic+"\x02", string(readFile(t, 1)[:5]))
+
+		tx := db.Begin()
+		require.NoError(t, col.UpdateTx(tx, 2, &testData{Foo: large + "tx"}))
+		require.NoError(t, tx.Commit())
+		require.Equal(t, compressionMagic+"\x02", string(readFile(t, 2)[:5]))
+
+		require.NoError(t, col.Close())
+
+		// indexes are rebuilt from the compressed files
+		col = open(t)
+		found, err := col.QueryBuilder().Where("foo", "=", large+"tx").Execute()
+		require.NoError(t, err)
+		require.Len(t, found, 1)
+		require.Equal(t, uint64(2), found[0].ID)
+	})
+
+	t.Run("invalid level", func(t *testing.T) {
+		_, err := NewFlatDBCollection[testData](db, "other-collection", logger, WithCompression[testData](CompressionGzip, 42, 0))
+		require.Error(t, err)
+	})
+}
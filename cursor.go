@@ -0,0 +1,383 @@
+package goflatdb
+
+import (
+	"context"
+	"errors"
+)
+
+// Cursor iterates over the results of a query, reading documents lazily:
+//
+//	cur, err := col.QueryBuilder().Where("Foo", "=", "bar").Limit(10).Iter(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	defer cur.Close()
+//
+//	for cur.Next() {
+//		doc := cur.Doc()
+//		...
+//	}
+//	if err := cur.Err(); err != nil {
+//		return err
+//	}
+type Cursor[T any] interface {
+	// Next advances the cursor to the next document. It returns false when there are no more documents,
+	// an error occurred or the cursor was closed.
+	Next() bool
+	// Doc returns the document the cursor points to.
+	Doc() FlatDBModel[T]
+	// Err returns the error that stopped the cursor, if any.
+	Err() error
+	// Close releases the cursor.
+	Close() error
+}
+
+// collect reads all documents of cur and closes it.
+func collect[T any](cur Cursor[T]) ([]FlatDBModel[T], error) {
+	defer func() {
+		_ = cur.Close()
+	}()
+
+	res := []FlatDBModel[T]{}
+	for cur.Next() {
+		res = append(res, cur.Doc())
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// documentCursor reads the documents stored in fileNames one by one, skipping the ones rejected by match
+// and the ones deleted after fileNames was listed.
+type documentCursor[T any] struct {
+	ctx context.Context
+	col *FlatDBCollection[T]
+
+	fileNames []string
+	match     func(doc FlatDBModel[T]) (bool, error) // nil accepts every document
+
+	doc FlatDBModel[T]
+	err error
+}
+
+func (c *documentCursor[T]) Next() bool {
+	for c.err == nil && len(c.fileNames) > 0 {
+		if err := c.ctx.Err(); err != nil {
+			c.err = err
+			return false
+		}
+
+		fileName := c.fileNames[0]
+		c.fileNames = c.fileNames[1:]
+
+		doc, err := c.col.readDocument(documentFilePath(c.col.dir.Name(), fileName))
+		if err != nil {
+			if errors.Is(err, DocumentNotFound) {
+				continue
+			}
+
+			c.err = err
+			return false
+		}
+
+		if c.match != nil {
+			ok, err := c.match(doc)
+			if err != nil {
+				c.err = err
+				return false
+			}
+
+			if !ok {
+				continue
+			}
+		}
+
+		c.doc = doc
+		return true
+	}
+
+	return false
+}
+
+func (c *documentCursor[T]) Doc() FlatDBModel[T] {
+	return c.doc
+}
+
+func (c *documentCursor[T]) Err() error {
+	return c.err
+}
+
+func (c *documentCursor[T]) Close() error {
+	c.fileNames = nil
+	return nil
+}
+
+// filterCursor returns the documents of cur accepted by match.
+type filterCursor[T any] struct {
+	cur   Cursor[T]
+	match func(doc FlatDBModel[T]) (bool, error)
+
+	err error
+}
+
+func (c *filterCursor[T]) Next() bool {
+	for c.err == nil && c.cur.Next() {
+		ok, err := c.match(c.cur.Doc())
+		if err != nil {
+			c.err = err
+			return false
+		}
+
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *filterCursor[T]) Doc() FlatDBModel[T] {
+	return c.cur.Doc()
+}
+
+func (c *filterCursor[T]) Err() error {
+	if c.err != nil {
+		return c.err
+	}
+
+	return c.cur.Err()
+}
+
+func (c *filterCursor[T]) Close() error {
+	return c.cur.Close()
+}
+
+// unionCursor merges two cursors ordered by id into one ordered by id, returning documents present in both once.
+type unionCursor[T any] struct {
+	left  Cursor[T]
+	right Cursor[T]
+
+	started bool
+	leftOk  bool
+	rightOk bool
+
+	doc FlatDBModel[T]
+}
+
+func (c *unionCursor[T]) Next() bool {
+	if !c.started {
+		c.started = true
+		c.leftOk = c.left.Next()
+		c.rightOk = c.right.Next()
+	}
+
+	if c.Err() != nil {
+		return false
+	}
+
+	switch {
+	case c.leftOk && c.rightOk:
+		left, right := c.left.Doc(), c.right.Doc()
+		switch {
+		case left.ID < right.ID:
+			c.doc = left
+			c.leftOk = c.left.Next()
+		case left.ID > right.ID:
+			c.doc = right
+			c.rightOk = c.right.Next()
+		default:
+			c.doc = left
+			c.leftOk = c.left.Next()
+			c.rightOk = c.right.Next()
+		}
+	case c.leftOk:
+		c.doc = c.left.Doc()
+		c.leftOk = c.left.Next()
+	case c.rightOk:
+		c.doc = c.right.Doc()
+		c.rightOk = c.right.Next()
+	default:
+		return false
+	}
+
+	return true
+}
+
+func (c *unionCursor[T]) Doc() FlatDBModel[T] {
+	return c.doc
+}
+
+func (c *unionCursor[T]) Err() error {
+	if err := c.left.Err(); err != nil {
+		return err
+	}
+
+	return c.right.Err()
+}
+
+func (c *unionCursor[T]) Close() error {
+	return errors.Join(c.left.Close(), c.right.Close())
+}
+
+// distinctCursor returns the documents of cursors one after another, skipping ids it has already returned.
+type distinctCursor[T any] struct {
+	cursors []Cursor[T]
+
+	seen map[uint64]struct{}
+	i    int
+}
+
+func (c *distinctCursor[T]) Next() bool {
+	for ; c.i < len(c.cursors); c.i++ {
+		cur := c.cursors[c.i]
+		for cur.Next() {
+			if _, ok := c.seen[cur.Doc().ID]; ok {
+				continue
+			}
+
+			c.seen[cur.Doc().ID] = struct{}{}
+			return true
+		}
+
+		if cur.Err() != nil {
+			return false
+		}
+	}
+
+	return false
+}
+
+func (c *distinctCursor[T]) Doc() FlatDBModel[T] {
+	return c.cursors[c.i].Doc()
+}
+
+func (c *distinctCursor[T]) Err() error {
+	if c.i < len(c.cursors) {
+		return c.cursors[c.i].Err()
+	}
+
+	return nil
+}
+
+func (c *distinctCursor[T]) Close() error {
+	errs := make([]error, 0, len(c.cursors))
+	for _, cur := range c.cursors {
+		errs = append(errs, cur.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// limitCursor returns at most limit documents of cur.
+type limitCursor[T any] struct {
+	cur   Cursor[T]
+	limit int
+}
+
+func (c *limitCursor[T]) Next() bool {
+	if c.limit <= 0 {
+		return false
+	}
+
+	c.limit--
+
+	return c.cur.Next()
+}
+
+func (c *limitCursor[T]) Doc() FlatDBModel[T] {
+	return c.cur.Doc()
+}
+
+func (c *limitCursor[T]) Err() error {
+	return c.cur.Err()
+}
+
+func (c *limitCursor[T]) Close() error {
+	return c.cur.Close()
+}
+
+// offsetCursor skips the first offset documents of cur.
+type offsetCursor[T any] struct {
+	cur    Cursor[T]
+	offset int
+}
+
+func (c *offsetCursor[T]) Next() bool {
+	for ; c.offset > 0; c.offset-- {
+		if !c.cur.Next() {
+			return false
+		}
+	}
+
+	return c.cur.Next()
+}
+
+func (c *offsetCursor[T]) Doc() FlatDBModel[T] {
+	return c.cur.Doc()
+}
+
+func (c *offsetCursor[T]) Err() error {
+	return c.cur.Err()
+}
+
+func (c *offsetCursor[T]) Close() error {
+	return c.cur.Close()
+}
+
+// groupCursor reads groups of file names one group at a time, sorting every group by keys.
+type groupCursor[T any] struct {
+	ctx context.Context
+	col *FlatDBCollection[T]
+
+	groups [][]string
+	keys   []orderKey
+
+	docs []FlatDBModel[T]
+	doc  FlatDBModel[T]
+	err  error
+}
+
+func (c *groupCursor[T]) Next() bool {
+	for c.err == nil && len(c.docs) == 0 && len(c.groups) > 0 {
+		if err := c.ctx.Err(); err != nil {
+			c.err = err
+			return false
+		}
+
+		docs, err := c.col.readDocuments(c.groups[0])
+		if err != nil {
+			c.err = err
+			return false
+		}
+		c.groups = c.groups[1:]
+
+		sortDocuments(docs, c.keys)
+		c.docs = docs
+	}
+
+	if c.err != nil || len(c.docs) == 0 {
+		return false
+	}
+
+	c.doc = c.docs[0]
+	c.docs = c.docs[1:]
+
+	return true
+}
+
+func (c *groupCursor[T]) Doc() FlatDBModel[T] {
+	return c.doc
+}
+
+func (c *groupCursor[T]) Err() error {
+	return c.err
+}
+
+func (c *groupCursor[T]) Close() error {
+	c.groups = nil
+	c.docs = nil
+	return nil
+}
@@ -0,0 +1,105 @@
+package goflatdb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCursor(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	db, err := NewFlatDB(dir, logger)
+	require.NoError(t, err)
+
+	col, err := NewFlatDBCollection[queryTestData](db, "test-collection", logger)
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		_, err := col.Insert(&queryTestData{
+			Foo: fmt.Sprintf("%d", i%2),
+			Bar: fmt.Sprintf("%d", i%3),
+		})
+		require.NoError(t, err)
+	}
+
+	// every query below has to stop before it reaches the corrupt document
+	err = os.WriteFile(filepath.Join(dir, "test-collection", col.documentFileName(90)), []byte(`{"data":`), 0666)
+	require.NoError(t, err)
+
+	iterIDs := func(t *testing.T, q *QueryBuilder[queryTestData]) []uint64 {
+		cur, err := q.Iter(context.Background())
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, cur.Close())
+		}()
+
+		ids := []uint64{}
+		for cur.Next() {
+			ids = append(ids, cur.Doc().ID)
+		}
+		require.NoError(t, cur.Err())
+
+		return ids
+	}
+
+	t.Run("full scan reads every document", func(t *testing.T) {
+		_, err := col.QueryBuilder().Select().Execute()
+		require.Error(t, err)
+	})
+
+	t.Run("limit stops reading", func(t *testing.T) {
+		ids := iterIDs(t, col.QueryBuilder().Select().Limit(10))
+		require.Equal(t, []uint64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, ids)
+	})
+
+	t.Run("offset and limit", func(t *testing.T) {
+		ids := iterIDs(t, col.QueryBuilder().Select().Offset(5).Limit(3))
+		require.Equal(t, []uint64{6, 7, 8}, ids)
+	})
+
+	t.Run("where, and, or compose lazily", func(t *testing.T) {
+		ids := iterIDs(t, col.QueryBuilder().Where("Foo", "=", "0").Limit(3))
+		require.Equal(t, []uint64{1, 3, 5}, ids)
+
+		ids = iterIDs(t, col.QueryBuilder().
+			Where("Foo", "=", "0").
+			And(col.QueryBuilder().Where("Bar", "=", "0")).
+			Limit(3))
+		require.Equal(t, []uint64{1, 7, 13}, ids)
+
+		ids = iterIDs(t, col.QueryBuilder().
+			Where("Foo", "=", "0").
+			Or(col.QueryBuilder().Where("Bar", "=", "1")).
+			Limit(4))
+		require.Equal(t, []uint64{1, 2, 3, 5}, ids)
+	})
+
+	t.Run("and with a non matchable side", func(t *testing.T) {
+		ids := iterIDs(t, col.QueryBuilder().
+			Where("Foo", "=", "1").
+			And(col.QueryBuilder().Select().Limit(6)))
+		require.Equal(t, []uint64{2, 4, 6}, ids)
+	})
+
+	t.Run("cancelled context stops the cursor", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		cur, err := col.QueryBuilder().Select().Iter(ctx)
+		require.NoError(t, err)
+
+		require.True(t, cur.Next())
+		cancel()
+		require.False(t, cur.Next())
+		require.ErrorIs(t, cur.Err(), context.Canceled)
+		require.NoError(t, cur.Close())
+	})
+}
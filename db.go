@@ -2,17 +2,21 @@ package goflatdb
 
 import (
 	"bufio"
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -22,6 +26,8 @@ type FlatDB struct {
 	name string
 
 	logger *zap.Logger
+
+	commitMu sync.Mutex // serializes transaction commits, which share the transaction log
 }
 
 type InsertResult struct {
@@ -29,13 +35,15 @@ type InsertResult struct {
 }
 
 type flatDBIndexUnorderedIndex struct {
-	ordered   bool
 	fieldName string
+	path      fieldPath
+	unique    bool
 
 	data map[interface{}][]string // key - fieldName, val - fileNames
 }
 
 type FlatDBCollection[T any] struct {
+	db   *FlatDB
 	name string
 	dir  *os.File
 
@@ -44,6 +52,32 @@ type FlatDBCollection[T any] struct {
 	mu               sync.RWMutex
 	idFile           *os.File
 	unorderedIndexes map[string]*flatDBIndexUnorderedIndex
+	orderedIndexes   map[string]*flatDBOrderedIndex
+	compositeIndexes map[string]*flatDBCompositeIndex // key - field names joined by commas
+
+	durability Durability
+	wal        *writeAheadLog
+	walEnabled bool
+
+	changeLog        *changeLog
+	changeLogEnabled bool
+
+	hooks collectionHooks[T]
+
+	validationEnabled bool
+	rules             *structRules // nil if T has no validation rules
+
+	migrations map[uint64]Migration // key - schema version the migration upgrades to
+
+	codec Codec
+
+	compression        Compression
+	compressionLevel   int
+	compressionMinSize int
+	compressor         *compressor
+
+	hasIndexSnapshot         bool
+	indexSnapshotHighWaterID uint64 // documents with a higher id are not covered by the index snapshot
 }
 
 func NewFlatDB(dir string, logger *zap.Logger) (*FlatDB, error) {
@@ -51,6 +85,10 @@ func NewFlatDB(dir string, logger *zap.Logger) (*FlatDB, error) {
 
 	dbLogger := logger.With(zap.String("db", name))
 
+	if err := recoverTxLog(dir, dbLogger); err != nil {
+		return nil, fmt.Errorf("error creating FlatDB %s: %w", name, err)
+	}
+
 	return &FlatDB{
 		name:   name,
 		dir:    dir,
@@ -58,6 +96,19 @@ func NewFlatDB(dir string, logger *zap.Logger) (*FlatDB, error) {
 	}, nil
 }
 
+// HasCollection reports whether collection name has been created, without creating it.
+func (db *FlatDB) HasCollection(name string) (bool, error) {
+	_, err := os.Stat(filepath.Join(db.dir, name, "id.txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
 type FlatDBCollectionOption[T any] func(db *FlatDBCollection[T])
 
 func NewFlatDBCollection[T any](db *FlatDB, name string, logger *zap.Logger, opts ...FlatDBCollectionOption[T]) (*FlatDBCollection[T], error) {
@@ -90,17 +141,62 @@ func NewFlatDBCollection[T any](db *FlatDB, name string, logger *zap.Logger, opt
 	collectionLogger := logger.With(zap.String("collection", name))
 
 	col := &FlatDBCollection[T]{
+		db:               db,
 		name:             name,
 		dir:              dirFile,
 		logger:           collectionLogger,
 		idFile:           idFile,
 		unorderedIndexes: map[string]*flatDBIndexUnorderedIndex{},
+		orderedIndexes:   map[string]*flatDBOrderedIndex{},
+		compositeIndexes: map[string]*flatDBCompositeIndex{},
+		durability:       DurabilityFileAndDirSync,
+		migrations:       map[uint64]Migration{},
+		codec:            JSONCodec,
 	}
 
 	for _, opt := range opts {
 		opt(col)
 	}
 
+	if err := col.parseIndexPaths(); err != nil {
+		return nil, errorCreatingFlatDBCollection(name, err)
+	}
+
+	col.compressor, err = newCompressor(col.compression, col.compressionLevel, col.compressionMinSize)
+	if err != nil {
+		return nil, errorCreatingFlatDBCollection(name, err)
+	}
+
+	if err := col.checkCodec(); err != nil {
+		return nil, errorCreatingFlatDBCollection(name, err)
+	}
+
+	if err := col.checkMigrations(); err != nil {
+		return nil, errorCreatingFlatDBCollection(name, err)
+	}
+
+	if col.validationEnabled {
+		col.rules, err = compileRules(dataType[T](), map[reflect.Type]*structRules{})
+		if err != nil {
+			return nil, errorCreatingFlatDBCollection(name, err)
+		}
+	}
+
+	// the change events of writes interrupted by a crash are recorded when their intents are replayed
+	if col.walEnabled || col.changeLogEnabled {
+		col.wal, err = openWriteAheadLog(filepath.Join(dir, walFileName), col.durability)
+		if err != nil {
+			return nil, errorCreatingFlatDBCollection(name, err)
+		}
+	}
+
+	if col.changeLogEnabled {
+		col.changeLog, err = openChangeLog(filepath.Join(dir, changeLogFileName), col.durability)
+		if err != nil {
+			return nil, errorCreatingFlatDBCollection(name, err)
+		}
+	}
+
 	if err := col.Init(); err != nil {
 		return nil, err
 	}
@@ -109,23 +205,50 @@ func NewFlatDBCollection[T any](db *FlatDB, name string, logger *zap.Logger, opt
 }
 
 func (c *FlatDBCollection[T]) Init() error {
+	return c.InitContext(context.Background())
+}
+
+// InitContext is like Init, but stops reading documents once ctx is done.
+func (c *FlatDBCollection[T]) InitContext(ctx context.Context) error {
 	c.logger.Info("running init...")
 
-	if len(c.unorderedIndexes) == 0 {
+	if err := removeTempFiles(c.dir.Name()); err != nil {
+		return errorInitializingFlatDBCollection(c.dir.Name(), err)
+	}
+
+	// until the snapshot is loaded, assume it covers every document
+	_, err := os.Stat(documentFilePath(c.dir.Name(), indexSnapshotFileName))
+	c.hasIndexSnapshot = err == nil
+	c.indexSnapshotHighWaterID = math.MaxUint64
+
+	if err := c.recoverWriteAheadLog(); err != nil {
+		return errorInitializingFlatDBCollection(c.dir.Name(), err)
+	}
+
+	if len(c.unorderedIndexes) == 0 && len(c.orderedIndexes) == 0 && len(c.compositeIndexes) == 0 {
 		return nil
 	}
 
-	files, err := os.ReadDir(c.dir.Name())
+	highWaterID, snapshotLoaded := c.loadIndexSnapshot()
+	if snapshotLoaded {
+		c.logger.Info("loaded index snapshot", zap.Uint64("highWaterID", highWaterID))
+	}
+
+	fileNames, err := c.documentFileNames()
 	if err != nil {
 		return errorInitializingFlatDBCollection(c.dir.Name(), err)
 	}
 
-	for _, f := range files {
-		if !strings.HasSuffix(f.Name(), ".json") {
+	for _, fileName := range fileNames {
+		if err := ctx.Err(); err != nil {
+			return errorInitializingFlatDBCollection(c.dir.Name(), err)
+		}
+
+		if snapshotLoaded && documentIDFromFileName(fileName) <= highWaterID {
 			continue
 		}
 
-		docPath := documentFilePath(c.dir.Name(), f.Name())
+		docPath := documentFilePath(c.dir.Name(), fileName)
 		doc, err := c.readDocument(docPath)
 		if err != nil {
 			return errorInitializingFlatDBCollection(c.dir.Name(), err)
@@ -134,6 +257,10 @@ func (c *FlatDBCollection[T]) Init() error {
 		c.updateIndexes(doc)
 	}
 
+	if err := c.uniqueIndexViolations(); err != nil {
+		return errorInitializingFlatDBCollection(c.dir.Name(), err)
+	}
+
 	return nil
 }
 
@@ -145,19 +272,209 @@ func (c *FlatDBCollection[T]) QueryBuilder() *QueryBuilder[T] {
 }
 
 func (c *FlatDBCollection[T]) updateIndexes(doc FlatDBModel[T]) {
-	refVal := reflect.ValueOf(doc.Data)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.indexDocument(doc)
+}
+
+// indexDocument adds doc to every index, under every value the index path resolves to. Caller must hold c.mu.
+func (c *FlatDBCollection[T]) indexDocument(doc FlatDBModel[T]) {
+	fileName := c.documentFileName(doc.ID)
+	for _, index := range c.unorderedIndexes {
+		for _, key := range indexKeys(doc, index.path) {
+			index.add(key, fileName)
+		}
+	}
+	for _, index := range c.orderedIndexes {
+		for _, key := range indexKeys(doc, index.path) {
+			index.add(key, fileName)
+		}
+	}
+	for _, index := range c.compositeIndexes {
+		for _, key := range index.keys(doc) {
+			index.add(key, fileName)
+		}
+	}
+}
+
+// unindexDocument removes doc from every index. Caller must hold c.mu.
+func (c *FlatDBCollection[T]) unindexDocument(doc FlatDBModel[T]) {
+	fileName := c.documentFileName(doc.ID)
+	for _, index := range c.unorderedIndexes {
+		for _, key := range indexKeys(doc, index.path) {
+			index.remove(key, fileName)
+		}
+	}
+	for _, index := range c.orderedIndexes {
+		for _, key := range indexKeys(doc, index.path) {
+			index.remove(key, fileName)
+		}
+	}
+	for _, index := range c.compositeIndexes {
+		for _, key := range index.keys(doc) {
+			index.remove(key, fileName)
+		}
+	}
+}
+
+// parseIndexPaths parses the field names of all indexes and renames the indexes to the canonical form
+// of their paths, so queries naming a field by its Go name or by its tag name find the same index.
+func (c *FlatDBCollection[T]) parseIndexPaths() error {
+	unorderedIndexes := make(map[string]*flatDBIndexUnorderedIndex, len(c.unorderedIndexes))
+	for _, index := range c.unorderedIndexes {
+		path, err := parsePath(index.fieldName)
+		if err != nil {
+			return err
+		}
+
+		index.path = canonicalPath(dataType[T](), path)
+		index.fieldName = index.path.String()
+		unorderedIndexes[index.fieldName] = index
+	}
+
+	orderedIndexes := make(map[string]*flatDBOrderedIndex, len(c.orderedIndexes))
+	for _, index := range c.orderedIndexes {
+		path, err := parsePath(index.fieldName)
+		if err != nil {
+			return err
+		}
+
+		index.path = canonicalPath(dataType[T](), path)
+		index.fieldName = index.path.String()
+		orderedIndexes[index.fieldName] = index
+	}
+
+	compositeIndexes := make(map[string]*flatDBCompositeIndex, len(c.compositeIndexes))
+	for _, index := range c.compositeIndexes {
+		index.paths = make([]fieldPath, len(index.fieldNames))
+		for i, fieldName := range index.fieldNames {
+			path, err := parsePath(fieldName)
+			if err != nil {
+				return err
+			}
+
+			index.paths[i] = canonicalPath(dataType[T](), path)
+			index.fieldNames[i] = index.paths[i].String()
+		}
+		compositeIndexes[compositeIndexName(index.fieldNames)] = index
+	}
+
+	c.unorderedIndexes = unorderedIndexes
+	c.orderedIndexes = orderedIndexes
+	c.compositeIndexes = compositeIndexes
+
+	return nil
+}
+
+// indexName returns the name of the indexes on fieldName, which is the canonical form of its path.
+func (c *FlatDBCollection[T]) indexName(fieldName string) string {
+	path, err := parsePath(fieldName)
+	if err != nil {
+		return fieldName
+	}
+
+	return canonicalPath(dataType[T](), path).String()
+}
+
+// dataType returns the reflect.Type of T.
+func dataType[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// checkUniqueIndexes returns ErrUniqueViolation if a document other than doc has a value doc has
+// for a uniquely indexed field. Caller must hold c.mu.
+func (c *FlatDBCollection[T]) checkUniqueIndexes(doc FlatDBModel[T]) error {
+	fileName := c.documentFileName(doc.ID)
 	for _, index := range c.unorderedIndexes {
-		fieldVal := refVal.FieldByName(index.fieldName)
-		if !fieldVal.IsValid() {
+		if !index.unique {
 			continue
 		}
 
-		fileName := documentFileName(doc.ID)
+		for _, key := range indexKeys(doc, index.path) {
+			for _, name := range index.data[key] {
+				if name != fileName {
+					return &ErrUniqueViolation{Field: index.fieldName, Value: key, ConflictingID: documentIDFromFileName(name)}
+				}
+			}
+		}
+	}
 
-		c.mu.Lock()
-		index.data[fieldVal.Interface()] = append(index.data[fieldVal.Interface()], fileName)
-		c.mu.Unlock()
+	return nil
+}
+
+// uniqueIndexViolations reports every document that shares the value of a uniquely indexed field
+// with a document of a lower id.
+func (c *FlatDBCollection[T]) uniqueIndexViolations() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	fieldNames := make([]string, 0, len(c.unorderedIndexes))
+	for fieldName, index := range c.unorderedIndexes {
+		if index.unique {
+			fieldNames = append(fieldNames, fieldName)
+		}
 	}
+	sort.Strings(fieldNames)
+
+	var errs []error
+	for _, fieldName := range fieldNames {
+		type violation struct {
+			id  uint64
+			err error
+		}
+
+		violations := []violation{}
+		for key, fileNames := range c.unorderedIndexes[fieldName].data {
+			if len(fileNames) < 2 {
+				continue
+			}
+
+			fileNames = append([]string(nil), fileNames...)
+			sortFileNamesByID(fileNames)
+
+			firstID := documentIDFromFileName(fileNames[0])
+			for _, fileName := range fileNames[1:] {
+				id := documentIDFromFileName(fileName)
+				violations = append(violations, violation{
+					id:  id,
+					err: fmt.Errorf("document %d: %w", id, &ErrUniqueViolation{Field: fieldName, Value: key, ConflictingID: firstID}),
+				})
+			}
+		}
+
+		sort.Slice(violations, func(i, j int) bool {
+			return violations[i].id < violations[j].id
+		})
+		for _, v := range violations {
+			errs = append(errs, v.err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (idx *flatDBIndexUnorderedIndex) add(key interface{}, fileName string) {
+	idx.data[key] = append(idx.data[key], fileName)
+}
+
+func (idx *flatDBIndexUnorderedIndex) remove(key interface{}, fileName string) {
+	fileNames := idx.data[key]
+	for i, name := range fileNames {
+		if name != fileName {
+			continue
+		}
+
+		fileNames = append(fileNames[:i], fileNames[i+1:]...)
+		break
+	}
+
+	if len(fileNames) == 0 {
+		delete(idx.data, key)
+		return
+	}
+
+	idx.data[key] = fileNames
 }
 
 func errorInitializingFlatDBCollection(name string, err error) error {
@@ -172,105 +489,257 @@ type FlatDBModel[T any] struct {
 	Data T `json:"data"`
 
 	ID uint64 `json:"ID"`
+	// Version is 1 for a new document and is incremented by every write to it. Documents written before
+	// versions were introduced have version 0.
+	Version uint64 `json:"version"`
+	// CreatedAt and UpdatedAt are the times of the insert and of the last write of the document, in UTC.
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Checksum is the CRC-32 of the encoded data, verified whenever the document is read.
+	Checksum uint32 `json:"checksum,omitempty"`
+	// SchemaVersion is the version of the schema of T the document was stored with, see WithMigration.
+	// Data is always migrated to the latest version. Documents written before schema versions were
+	// introduced have schema version 1.
+	SchemaVersion uint64 `json:"schemaVersion,omitempty"`
 }
 
 func (c *FlatDBCollection[T]) findBy(fieldName string, fieldValue interface{}) ([]FlatDBModel[T], error) {
-	res := []FlatDBModel[T]{}
-	{
-		c.mu.RLock()
-		defer c.mu.RUnlock()
-
-		idx := c.unorderedIndexes[fieldName]
-		if idx != nil {
-			fileNames, ok := idx.data[fieldValue]
-			if !ok {
-				return []FlatDBModel[T]{}, DocumentNotFound
-			}
-
-			for _, docFileName := range fileNames {
-				documentPath := documentFilePath(c.dir.Name(), docFileName)
-				doc, err := c.readDocument(documentPath)
-				if err != nil {
-					return []FlatDBModel[T]{}, errorFindBy(fieldName, fieldValue, err)
-				}
-				res = append(res, doc)
-			}
+	fileNames, indexed, err := c.lookupIndex(fieldName, OperatorEquals, fieldValue)
+	if err != nil {
+		return []FlatDBModel[T]{}, errorFindBy(fieldName, fieldValue, err)
+	}
 
-			return res, nil
-		}
+	if indexed && len(fileNames) == 0 {
+		return []FlatDBModel[T]{}, DocumentNotFound
 	}
 
-	c.logger.Info("running full scan in findBy query", zap.String("fieldName", fieldName), zap.Any("fieldValue", fieldValue))
+	cur, err := c.whereCursor(context.Background(), fieldName, OperatorEquals, fieldValue)
+	if err != nil {
+		return []FlatDBModel[T]{}, err
+	}
 
-	files, err := os.ReadDir(c.dir.Name())
+	docs, err := collect(cur)
 	if err != nil {
 		return []FlatDBModel[T]{}, errorFindBy(fieldName, fieldValue, err)
 	}
 
-	for _, f := range files {
-		if !strings.HasSuffix(f.Name(), ".json") {
-			continue
+	return docs, nil
+}
+
+// whereCursor returns a cursor over documents whose fieldName satisfies operator with fieldValue, ordered by id.
+// Equality and "in" are answered by an index on fieldName, range operators and "prefix" by an ordered index on fieldName.
+// Other operators, and operators without a suitable index, run a filtered full scan.
+func (c *FlatDBCollection[T]) whereCursor(ctx context.Context, fieldName string, operator QueryOperator, fieldValue interface{}) (Cursor[T], error) {
+	path, err := parsePath(fieldName)
+	if err != nil {
+		return nil, errorFindBy(fieldName, fieldValue, fmt.Errorf("%w: %w", InvalidQuery, err))
+	}
+
+	match := func(doc FlatDBModel[T]) (bool, error) {
+		return matchValues(operator, path.lookup(doc), fieldValue)
+	}
+
+	fileNames, indexed, err := c.lookupIndex(fieldName, operator, fieldValue)
+	if err != nil {
+		return nil, errorFindBy(fieldName, fieldValue, err)
+	}
+
+	if indexed {
+		sortFileNamesByID(fileNames)
+
+		// documents may change after they were looked up in the index, so they are matched again when read
+		return &documentCursor[T]{
+			ctx:       ctx,
+			col:       c,
+			fileNames: fileNames,
+			match:     match,
+		}, nil
+	}
+
+	c.logger.Info("running full scan in where query", zap.String("fieldName", fieldName), zap.Any("fieldValue", fieldValue))
+
+	cur, err := c.scanCursor(ctx, match)
+	if err != nil {
+		return nil, errorFindBy(fieldName, fieldValue, err)
+	}
+
+	return cur, nil
+}
+
+// scanCursor returns a cursor over all documents of the collection accepted by match, ordered by id.
+// A nil match accepts every document.
+func (c *FlatDBCollection[T]) scanCursor(ctx context.Context, match func(doc FlatDBModel[T]) (bool, error)) (Cursor[T], error) {
+	fileNames, err := c.documentFileNames()
+	if err != nil {
+		return nil, err
+	}
+
+	return &documentCursor[T]{
+		ctx:       ctx,
+		col:       c,
+		fileNames: fileNames,
+		match:     match,
+	}, nil
+}
+
+// lookupIndex returns the file names of documents whose fieldName satisfies operator with fieldValue.
+// indexed is false if there is no index on fieldName suitable for operator.
+func (c *FlatDBCollection[T]) lookupIndex(fieldName string, operator QueryOperator, fieldValue interface{}) (fileNames []string, indexed bool, err error) {
+	fieldName = c.indexName(fieldName)
+
+	switch operator {
+	case OperatorEquals:
+		fileNames, indexed = c.lookupIndexIn(fieldName, []interface{}{fieldValue})
+		return fileNames, indexed, nil
+	case OperatorIn:
+		fileNames, indexed = c.lookupIndexIn(fieldName, fieldValue)
+		return fileNames, indexed, nil
+	default:
+		return c.lookupIndexRange(fieldName, operator, fieldValue)
+	}
+}
+
+// lookupIndexIn returns the file names of documents whose fieldName equals any element of list.
+// indexed is false if fieldName is not indexed or an element of list can't be an index key.
+func (c *FlatDBCollection[T]) lookupIndexIn(fieldName string, list interface{}) (fileNames []string, indexed bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	unorderedIdx := c.unorderedIndexes[fieldName]
+	orderedIdx := c.orderedIndexes[fieldName]
+	if unorderedIdx == nil && orderedIdx == nil {
+		return nil, false
+	}
+
+	// values such as slices can't be index keys, so the documents are matched by a scan instead
+	listVal := reflect.ValueOf(list)
+	for i := 0; i < listVal.Len(); i++ {
+		if !isIndexKey(listVal.Index(i).Interface()) {
+			return nil, false
+		}
+	}
+
+	seen := map[string]struct{}{}
+	for i := 0; i < listVal.Len(); i++ {
+		key := normalizeValue(listVal.Index(i).Interface())
+
+		var names []string
+		if unorderedIdx != nil {
+			names = unorderedIdx.data[key]
+		} else {
+			names, _ = orderedIdx.get(key)
 		}
 
-		doc, err := c.readDocument(documentFilePath(c.dir.Name(), f.Name()))
+		for _, name := range names {
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			fileNames = append(fileNames, name)
+		}
+	}
+
+	return fileNames, true
+}
+
+// isIndexKey reports whether v can be looked up in an index, which holds only hashable keys.
+func isIndexKey(v interface{}) bool {
+	return isHashable(reflect.ValueOf(normalizeValue(v)))
+}
+
+// lookupIndexRange returns the file names of documents whose fieldName satisfies a range operator or "prefix".
+// indexed is false if there is no ordered index on fieldName or operator can't use it.
+func (c *FlatDBCollection[T]) lookupIndexRange(fieldName string, operator QueryOperator, fieldValue interface{}) (fileNames []string, indexed bool, err error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	idx := c.orderedIndexes[fieldName]
+	if idx == nil {
+		return nil, false, nil
+	}
+
+	lower, upper, ok := operatorBounds(operator, fieldValue)
+	if !ok {
+		return nil, false, nil
+	}
+
+	seen := map[string]struct{}{}
+
+	idx.scan(lower, upper, func(key interface{}, names []string) bool {
+		var match bool
+		match, err = matchOperator(operator, reflect.ValueOf(key), fieldValue)
 		if err != nil {
-			return []FlatDBModel[T]{}, errorFindBy(fieldName, fieldValue, err)
+			return false
 		}
 
-		val := reflect.ValueOf(doc.Data).FieldByName(fieldName)
-		if !val.IsValid() {
-			continue
+		if !match {
+			// keys sharing a prefix are adjacent, so the first key without it ends the scan
+			return operator != OperatorPrefix
 		}
 
-		if !reflect.DeepEqual(val.Interface(), fieldValue) {
-			continue
+		// documents indexed under several keys are returned once
+		for _, name := range names {
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			fileNames = append(fileNames, name)
 		}
 
-		res = append(res, doc)
+		return true
+	})
+	if err != nil {
+		return nil, true, err
 	}
 
-	return res, nil
+	return fileNames, true, nil
 }
 
-func (c *FlatDBCollection[T]) findAll() ([]FlatDBModel[T], error) {
-	res := []FlatDBModel[T]{}
-
-	c.logger.Info("running full scan")
-
+// documentFileNames returns the file names of all documents in the collection, ordered by id.
+func (c *FlatDBCollection[T]) documentFileNames() ([]string, error) {
 	files, err := os.ReadDir(c.dir.Name())
 	if err != nil {
-		return []FlatDBModel[T]{}, errorFindAll(err)
+		return nil, err
 	}
 
+	fileNames := make([]string, 0, len(files))
 	for _, f := range files {
-		if !strings.HasSuffix(f.Name(), ".json") {
+		if !strings.HasSuffix(f.Name(), c.codec.Extension()) {
 			continue
 		}
 
-		doc, err := c.readDocument(documentFilePath(c.dir.Name(), f.Name()))
-		if err != nil {
-			return []FlatDBModel[T]{}, errorFindAll(err)
-		}
-
-		res = append(res, doc)
+		fileNames = append(fileNames, f.Name())
 	}
 
-	return res, nil
+	sortFileNamesByID(fileNames)
+
+	return fileNames, nil
+}
+
+// sortFileNamesByID sorts document file names by the id they encode.
+func sortFileNamesByID(fileNames []string) {
+	sort.Slice(fileNames, func(i, j int) bool {
+		return documentIDFromFileName(fileNames[i]) < documentIDFromFileName(fileNames[j])
+	})
 }
 
 func errorFindBy(fieldName string, val interface{}, err error) error {
 	return fmt.Errorf("error findBy %s=%v: %w", fieldName, val, err)
 }
 
-func errorFindAll(err error) error {
-	return fmt.Errorf("error findAll: %w", err)
+func (c *FlatDBCollection[T]) GetByID(id uint64) (FlatDBModel[T], error) {
+	return c.GetByIDContext(context.Background(), id)
 }
 
-func (c *FlatDBCollection[T]) GetByID(id uint64) (FlatDBModel[T], error) {
+func (c *FlatDBCollection[T]) GetByIDContext(ctx context.Context, id uint64) (FlatDBModel[T], error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	doc, err := c.readDocument(documentFilePath(c.dir.Name(), documentFileName(id)))
+	if err := ctx.Err(); err != nil {
+		return FlatDBModel[T]{}, errorGettingDocumentByID(id, err)
+	}
+
+	doc, err := c.readDocument(documentFilePath(c.dir.Name(), c.documentFileName(id)))
 	if err != nil {
 		return FlatDBModel[T]{}, errorGettingDocumentByID(id, err)
 	}
@@ -282,6 +751,9 @@ func (c *FlatDBCollection[T]) readDocument(documentPath string) (FlatDBModel[T],
 	var bytes []byte
 	f, err := os.Open(documentPath)
 	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = fmt.Errorf("%w: %w", DocumentNotFound, err)
+		}
 		return FlatDBModel[T]{}, errorReadingDocument(documentPath, err)
 	}
 	defer func() {
@@ -294,8 +766,13 @@ func (c *FlatDBCollection[T]) readDocument(documentPath string) (FlatDBModel[T],
 		return FlatDBModel[T]{}, errorReadingDocument(documentPath, err)
 	}
 
-	result := FlatDBModel[T]{}
-	if err := json.Unmarshal(bytes, &result); err != nil {
+	bytes, err = decompressDocument(bytes)
+	if err != nil {
+		return FlatDBModel[T]{}, errorReadingDocument(documentPath, err)
+	}
+
+	result, err := c.decodeDocument(bytes)
+	if err != nil {
 		return result, errorReadingDocument(documentPath, err)
 	}
 
@@ -311,62 +788,356 @@ func errorGettingDocumentByID(id uint64, err error) error {
 }
 
 func (c *FlatDBCollection[T]) Insert(data *T) (InsertResult, error) {
-	id, err := c.GetNextID(c.idFile)
+	return c.InsertContext(context.Background(), data)
+}
+
+func (c *FlatDBCollection[T]) InsertContext(ctx context.Context, data *T) (InsertResult, error) {
+	model, err := c.insertDocument(ctx, data)
 	if err != nil {
-		return InsertResult{}, errInsertingIntoCollection(c.name, err)
+		return InsertResult{}, err
+	}
+
+	c.runAfterInsert(ctx, model)
+
+	return InsertResult{ID: model.ID}, nil
+}
+
+func (c *FlatDBCollection[T]) insertDocument(ctx context.Context, data *T) (FlatDBModel[T], error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return FlatDBModel[T]{}, errInsertingIntoCollection(c.name, err)
+	}
+
+	if err := c.runBeforeInsert(ctx, data); err != nil {
+		return FlatDBModel[T]{}, errInsertingIntoCollection(c.name, err)
+	}
+
+	if err := c.validate(data); err != nil {
+		return FlatDBModel[T]{}, errInsertingIntoCollection(c.name, err)
+	}
+
+	// no document has id 0, so every document with the same value is a conflict
+	if err := c.checkUniqueIndexes(FlatDBModel[T]{Data: *data}); err != nil {
+		return FlatDBModel[T]{}, errInsertingIntoCollection(c.name, err)
+	}
+
+	id, err := c.nextID(c.idFile)
+	if err != nil {
+		return FlatDBModel[T]{}, errInsertingIntoCollection(c.name, err)
 	}
 
 	model := FlatDBModel[T]{
-		Data: *data,
-		ID:   id,
+		Data:    *data,
+		ID:      id,
+		Version: 1,
 	}
-	bytes, err := json.Marshal(model)
+	model.touch(nil)
+	bytes, err := c.encodeDocument(&model)
 	if err != nil {
-		return InsertResult{}, errInsertingIntoCollection(c.name, err)
+		return FlatDBModel[T]{}, errInsertingIntoCollection(c.name, err)
 	}
 
-	c.updateIndexes(model)
+	if _, err := c.insertBytes(bytes, id); err != nil {
+		return FlatDBModel[T]{}, err
+	}
+
+	c.indexDocument(model)
 
-	return c.insertBytes(bytes, id)
+	return model, nil
 }
 
+// insertBytes writes the encoded document id. Caller must hold c.mu.
 func (c *FlatDBCollection[T]) insertBytes(data []byte, id uint64) (InsertResult, error) {
+	err := c.applyIntent(c.withChange(walRecord{Op: walOpInsert, ID: id, Data: data}, nil), func() error {
+		return c.writeDocument(data, id)
+	})
+	if err != nil {
+		return InsertResult{}, errInsertingIntoCollection(c.name, err)
+	}
+
+	return InsertResult{ID: id}, nil
+}
+
+// writeDocument atomically replaces the file of document id with data. Caller must hold c.mu.
+func (c *FlatDBCollection[T]) writeDocument(data []byte, id uint64) error {
+	data, err := c.compressor.compress(data)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(c.dir, c.documentFileName(id), data, c.durability)
+}
+
+// removeDocument removes the file of document id, if it exists, and fsyncs the collection directory
+// as writeDocument does, so the removal survives a crash. Caller must hold c.mu.
+func (c *FlatDBCollection[T]) removeDocument(id uint64) error {
+	err := os.Remove(documentFilePath(c.dir.Name(), c.documentFileName(id)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if c.durability >= DurabilityFileAndDirSync {
+		return c.dir.Sync()
+	}
+
+	return nil
+}
+
+// Update replaces the data of document id. It returns DocumentNotFound if there is no such document.
+func (c *FlatDBCollection[T]) Update(id uint64, data *T) error {
+	return c.UpdateContext(context.Background(), id, data)
+}
+
+func (c *FlatDBCollection[T]) UpdateContext(ctx context.Context, id uint64, data *T) error {
+	old, model, err := c.updateDocument(ctx, id, nil, data)
+	if err != nil {
+		return errUpdatingDocument(c.name, id, err)
+	}
+
+	c.runAfterUpdate(ctx, old, model)
+
+	return nil
+}
+
+// UpdateIfVersion replaces the data of document id if its version is still version, which makes
+// read-modify-write cycles safe against concurrent writers. It returns ErrVersionConflict if the document
+// was written since it was read, and DocumentNotFound if there is no such document.
+func (c *FlatDBCollection[T]) UpdateIfVersion(id uint64, version uint64, data *T) error {
+	return c.UpdateIfVersionContext(context.Background(), id, version, data)
+}
+
+func (c *FlatDBCollection[T]) UpdateIfVersionContext(ctx context.Context, id uint64, version uint64, data *T) error {
+	old, model, err := c.updateDocument(ctx, id, &version, data)
+	if err != nil {
+		return errUpdatingDocument(c.name, id, err)
+	}
+
+	c.runAfterUpdate(ctx, old, model)
+
+	return nil
+}
+
+// updateDocument replaces the data of document id, if version is nil or equals its version.
+// It returns the document before and after the update.
+func (c *FlatDBCollection[T]) updateDocument(ctx context.Context, id uint64, version *uint64, data *T) (old FlatDBModel[T], model FlatDBModel[T], err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	fileName := documentFileName(id)
+	if err := ctx.Err(); err != nil {
+		return FlatDBModel[T]{}, FlatDBModel[T]{}, err
+	}
 
-	docFilePath := documentFilePath(c.dir.Name(), fileName)
+	old, err = c.readDocument(documentFilePath(c.dir.Name(), c.documentFileName(id)))
+	if err != nil {
+		return FlatDBModel[T]{}, FlatDBModel[T]{}, err
+	}
+
+	if version != nil && old.Version != *version {
+		return FlatDBModel[T]{}, FlatDBModel[T]{}, &ErrVersionConflict{ID: id, Expected: *version, Actual: old.Version}
+	}
 
-	f, err := os.Create(docFilePath) // TODO: think about permissions
+	if err := c.runBeforeUpdate(ctx, old, data); err != nil {
+		return FlatDBModel[T]{}, FlatDBModel[T]{}, err
+	}
+
+	model, err = c.update(old, *data)
 	if err != nil {
-		return InsertResult{}, errInsertingIntoCollection(c.name, err)
+		return FlatDBModel[T]{}, FlatDBModel[T]{}, err
 	}
-	defer func() {
-		_ = f.Close()
-	}()
 
-	bufWriter := bufio.NewWriter(f)
-	_, err = bufWriter.Write(data)
+	return old, model, nil
+}
+
+// Patch applies a JSON Merge Patch (RFC 7396) to the data of document id and returns the patched document.
+func (c *FlatDBCollection[T]) Patch(id uint64, patch []byte) (FlatDBModel[T], error) {
+	return c.PatchContext(context.Background(), id, patch)
+}
+
+func (c *FlatDBCollection[T]) PatchContext(ctx context.Context, id uint64, patch []byte) (FlatDBModel[T], error) {
+	old, model, err := c.patchDocument(ctx, id, patch)
 	if err != nil {
-		return InsertResult{}, errInsertingIntoCollection(c.name, err)
+		return FlatDBModel[T]{}, errPatchingDocument(c.name, id, err)
 	}
 
-	if err := bufWriter.Flush(); err != nil {
-		return InsertResult{}, errInsertingIntoCollection(c.name, err)
+	c.runAfterUpdate(ctx, old, model)
+
+	return model, nil
+}
+
+// patchDocument applies patch to document id and returns the document before and after it.
+func (c *FlatDBCollection[T]) patchDocument(ctx context.Context, id uint64, patch []byte) (old FlatDBModel[T], model FlatDBModel[T], err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return FlatDBModel[T]{}, FlatDBModel[T]{}, err
 	}
 
-	return InsertResult{ID: id}, nil
+	old, err = c.readDocument(documentFilePath(c.dir.Name(), c.documentFileName(id)))
+	if err != nil {
+		return FlatDBModel[T]{}, FlatDBModel[T]{}, err
+	}
+
+	oldBytes, err := json.Marshal(old.Data)
+	if err != nil {
+		return FlatDBModel[T]{}, FlatDBModel[T]{}, err
+	}
+
+	patchedBytes, err := applyMergePatch(oldBytes, patch)
+	if err != nil {
+		return FlatDBModel[T]{}, FlatDBModel[T]{}, err
+	}
+
+	var data T
+	if err := json.Unmarshal(patchedBytes, &data); err != nil {
+		return FlatDBModel[T]{}, FlatDBModel[T]{}, err
+	}
+
+	if err := c.runBeforeUpdate(ctx, old, &data); err != nil {
+		return FlatDBModel[T]{}, FlatDBModel[T]{}, err
+	}
+
+	model, err = c.update(old, data)
+	if err != nil {
+		return FlatDBModel[T]{}, FlatDBModel[T]{}, err
+	}
+
+	return old, model, nil
+}
+
+// update replaces the data of the stored document old, bumps its version and moves it between index keys.
+// Caller must hold c.mu.
+func (c *FlatDBCollection[T]) update(old FlatDBModel[T], data T) (FlatDBModel[T], error) {
+	id := old.ID
+
+	model := FlatDBModel[T]{
+		Data:    data,
+		ID:      id,
+		Version: old.Version + 1,
+	}
+	model.touch(&old)
+
+	if err := c.validate(&model.Data); err != nil {
+		return FlatDBModel[T]{}, err
+	}
+
+	if err := c.checkUniqueIndexes(model); err != nil {
+		return FlatDBModel[T]{}, err
+	}
+
+	if err := c.invalidateIndexSnapshot(id); err != nil {
+		return FlatDBModel[T]{}, err
+	}
+
+	bytes, err := c.encodeDocument(&model)
+	if err != nil {
+		return FlatDBModel[T]{}, err
+	}
+
+	err = c.applyIntent(c.withChange(walRecord{Op: walOpUpdate, ID: id, Data: bytes}, nil), func() error {
+		return c.writeDocument(bytes, id)
+	})
+	if err != nil {
+		return FlatDBModel[T]{}, err
+	}
+
+	c.unindexDocument(old)
+	c.indexDocument(model)
+
+	return model, nil
+}
+
+// Delete removes document id. It returns DocumentNotFound if there is no such document.
+func (c *FlatDBCollection[T]) Delete(id uint64) error {
+	return c.DeleteContext(context.Background(), id)
+}
+
+func (c *FlatDBCollection[T]) DeleteContext(ctx context.Context, id uint64) error {
+	old, err := c.deleteDocument(ctx, id)
+	if err != nil {
+		return errDeletingDocument(c.name, id, err)
+	}
+
+	c.runAfterDelete(ctx, old)
+
+	return nil
+}
+
+// deleteDocument removes document id and returns it.
+func (c *FlatDBCollection[T]) deleteDocument(ctx context.Context, id uint64) (FlatDBModel[T], error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return FlatDBModel[T]{}, err
+	}
+
+	docPath := documentFilePath(c.dir.Name(), c.documentFileName(id))
+	old, err := c.readDocument(docPath)
+	if err != nil {
+		return FlatDBModel[T]{}, err
+	}
+
+	if err := c.runBeforeDelete(ctx, old); err != nil {
+		return FlatDBModel[T]{}, err
+	}
+
+	if err := c.invalidateIndexSnapshot(id); err != nil {
+		return FlatDBModel[T]{}, err
+	}
+
+	// the change event of a delete carries the deleted document
+	var oldBytes []byte
+	if c.changeLog != nil {
+		if oldBytes, err = c.encodeDocument(&old); err != nil {
+			return FlatDBModel[T]{}, err
+		}
+	}
+
+	err = c.applyIntent(c.withChange(walRecord{Op: walOpDelete, ID: id}, oldBytes), func() error {
+		return c.removeDocument(id)
+	})
+	if err != nil {
+		return FlatDBModel[T]{}, err
+	}
+
+	c.unindexDocument(old)
+
+	return old, nil
 }
 
 func (c *FlatDBCollection[T]) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if len(c.unorderedIndexes) > 0 || len(c.orderedIndexes) > 0 || len(c.compositeIndexes) > 0 {
+		if err := c.writeIndexSnapshot(); err != nil {
+			c.logger.Error("error writing index snapshot", zap.Error(err))
+		}
+	}
+
 	if err := c.idFile.Close(); err != nil {
 		c.logger.Error("error closing id file", zap.Error(err))
 	}
 
+	if c.wal != nil {
+		if err := c.wal.Close(); err != nil {
+			c.logger.Error("error closing write-ahead log", zap.Error(err))
+		}
+	}
+
+	if c.changeLog != nil {
+		if err := c.changeLog.Close(); err != nil {
+			c.logger.Error("error closing change log", zap.Error(err))
+		}
+	}
+
+	if err := c.compressor.Close(); err != nil {
+		c.logger.Error("error closing compressor", zap.Error(err))
+	}
+
 	if err := c.dir.Close(); err != nil {
 		c.logger.Error("error closing dir file", zap.Error(err))
 	}
@@ -382,14 +1153,42 @@ func errInsertingIntoCollection(collection string, err error) error {
 	return fmt.Errorf("error inserting into collection %s: %w", collection, err)
 }
 
-func documentFileName(id uint64) string {
-	return strconv.FormatUint(id, 10) + ".json"
+func errUpdatingDocument(collection string, id uint64, err error) error {
+	return fmt.Errorf("error updating document %d in collection %s: %w", id, collection, err)
+}
+
+func errPatchingDocument(collection string, id uint64, err error) error {
+	return fmt.Errorf("error patching document %d in collection %s: %w", id, collection, err)
+}
+
+func errDeletingDocument(collection string, id uint64, err error) error {
+	return fmt.Errorf("error deleting document %d in collection %s: %w", id, collection, err)
+}
+
+// documentFileName returns the name of the file of document id.
+func (c *FlatDBCollection[T]) documentFileName(id uint64) string {
+	return documentFileName(id, c.codec)
+}
+
+func documentFileName(id uint64, codec Codec) string {
+	return strconv.FormatUint(id, 10) + codec.Extension()
+}
+
+func documentIDFromFileName(fileName string) uint64 {
+	name, _, _ := strings.Cut(fileName, ".")
+	id, _ := strconv.ParseUint(name, 10, 64)
+	return id
 }
 
 func (c *FlatDBCollection[T]) GetNextID(idFile *os.File) (uint64, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	return c.nextID(idFile)
+}
+
+// nextID allocates the next document id. Caller must hold c.mu.
+func (c *FlatDBCollection[T]) nextID(idFile *os.File) (uint64, error) {
 	curID, err := readID(idFile)
 	if err != nil {
 		return 0, fmt.Errorf("error generating next id: %w", err)
@@ -400,6 +1199,12 @@ func (c *FlatDBCollection[T]) GetNextID(idFile *os.File) (uint64, error) {
 		return 0, fmt.Errorf("error generating next id: %w", err)
 	}
 
+	if c.durability >= DurabilityFileSync {
+		if err := idFile.Sync(); err != nil {
+			return 0, fmt.Errorf("error generating next id: %w", err)
+		}
+	}
+
 	return nextID, nil
 }
 
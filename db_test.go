@@ -1,10 +1,15 @@
 package goflatdb
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
@@ -294,6 +299,311 @@ func TestFlatDBCollectionGetByID(t *testing.T) {
 	})
 }
 
+func TestFlatDBCollectionUpdate(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	db, err := NewFlatDB(dir, logger)
+	require.NoError(t, err)
+
+	col, err := NewFlatDBCollection[testData](db, "test-collection", logger, WithUnorderedIndex[testData]("Foo"))
+	require.NoError(t, err)
+
+	_, err = col.Insert(&testData{Foo: "hello"})
+	require.NoError(t, err)
+	_, err = col.Insert(&testData{Foo: "hello"})
+	require.NoError(t, err)
+
+	err = col.Update(1, &testData{Foo: "world"})
+	require.NoError(t, err)
+
+	res, err := col.GetByID(1)
+	require.NoError(t, err)
+	require.Equal(t, testData{Foo: "world"}, res.Data)
+
+	docs, err := col.findBy("Foo", "hello")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(docs))
+	require.Equal(t, uint64(2), docs[0].ID)
+
+	docs, err = col.findBy("Foo", "world")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(docs))
+	require.Equal(t, uint64(1), docs[0].ID)
+
+	err = col.Update(3, &testData{Foo: "world"})
+	require.ErrorIs(t, err, DocumentNotFound)
+}
+
+func TestFlatDBCollectionUpdateIfVersion(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	db, err := NewFlatDB(dir, logger)
+	require.NoError(t, err)
+
+	col, err := NewFlatDBCollection[testData](db, "test-collection", logger)
+	require.NoError(t, err)
+
+	_, err = col.Insert(&testData{Foo: "hello"})
+	require.NoError(t, err)
+
+	doc, err := col.GetByID(1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), doc.Version)
+
+	err = col.UpdateIfVersion(1, doc.Version, &testData{Foo: "world"})
+	require.NoError(t, err)
+
+	// a writer still holding the first version loses
+	var conflict *ErrVersionConflict
+	err = col.UpdateIfVersion(1, doc.Version, &testData{Foo: "stale"})
+	require.ErrorAs(t, err, &conflict)
+	require.Equal(t, ErrVersionConflict{ID: 1, Expected: 1, Actual: 2}, *conflict)
+
+	doc, err = col.GetByID(1)
+	require.NoError(t, err)
+	require.Equal(t, testData{Foo: "world"}, doc.Data)
+	require.Equal(t, uint64(2), doc.Version)
+
+	err = col.Update(1, &testData{Foo: "again"})
+	require.NoError(t, err)
+	patched, err := col.Patch(1, []byte(`{"foo": "patched"}`))
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), patched.Version)
+
+	err = col.UpdateIfVersion(2, 1, &testData{Foo: "world"})
+	require.ErrorIs(t, err, DocumentNotFound)
+
+	t.Run("documents without a version have version 0", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "test-collection", "5.json"), []byte(`{"data":{"foo":"old"},"ID":5}`), 0666))
+
+		doc, err := col.GetByID(5)
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), doc.Version)
+
+		err = col.UpdateIfVersion(5, 0, &testData{Foo: "new"})
+		require.NoError(t, err)
+
+		doc, err = col.GetByID(5)
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), doc.Version)
+	})
+}
+
+func TestFlatDBCollectionPatch(t *testing.T) {
+	type patchTestData struct {
+		Foo string            `json:"foo"`
+		Bar int               `json:"bar"`
+		Baz map[string]string `json:"baz"`
+		N   uint64            `json:"n"`
+	}
+
+	dir := t.TempDir()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	db, err := NewFlatDB(dir, logger)
+	require.NoError(t, err)
+
+	col, err := NewFlatDBCollection[patchTestData](db, "test-collection", logger, WithUnorderedIndex[patchTestData]("Foo"))
+	require.NoError(t, err)
+
+	_, err = col.Insert(&patchTestData{Foo: "hello", Bar: 5, Baz: map[string]string{"a": "1", "b": "2"}, N: 1<<60 + 1})
+	require.NoError(t, err)
+
+	res, err := col.Patch(1, []byte(`{"foo":"world","baz":{"a":null,"c":"3"}}`))
+	require.NoError(t, err)
+
+	// large integers aren't rounded by the patch
+	expected := patchTestData{Foo: "world", Bar: 5, Baz: map[string]string{"b": "2", "c": "3"}, N: 1<<60 + 1}
+	require.Equal(t, expected, res.Data)
+
+	doc, err := col.GetByID(1)
+	require.NoError(t, err)
+	require.Equal(t, expected, doc.Data)
+
+	_, err = col.findBy("Foo", "hello")
+	require.ErrorIs(t, err, DocumentNotFound)
+
+	docs, err := col.findBy("Foo", "world")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(docs))
+
+	_, err = col.Patch(1, []byte(`{"foo":`))
+	require.Error(t, err)
+
+	_, err = col.Patch(1, []byte(`{"foo":"a"} {}`))
+	require.Error(t, err)
+
+	_, err = col.Patch(2, []byte(`{"foo":"world"}`))
+	require.ErrorIs(t, err, DocumentNotFound)
+}
+
+func TestFlatDBCollectionDelete(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	db, err := NewFlatDB(dir, logger)
+	require.NoError(t, err)
+
+	col, err := NewFlatDBCollection[testData](db, "test-collection", logger, WithUnorderedIndex[testData]("Foo"))
+	require.NoError(t, err)
+
+	_, err = col.Insert(&testData{Foo: "hello"})
+	require.NoError(t, err)
+	_, err = col.Insert(&testData{Foo: "hello"})
+	require.NoError(t, err)
+
+	err = col.Delete(1)
+	require.NoError(t, err)
+
+	_, err = col.GetByID(1)
+	require.ErrorIs(t, err, DocumentNotFound)
+
+	docs, err := col.findBy("Foo", "hello")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(docs))
+	require.Equal(t, uint64(2), docs[0].ID)
+
+	err = col.Delete(2)
+	require.NoError(t, err)
+
+	_, err = col.findBy("Foo", "hello")
+	require.ErrorIs(t, err, DocumentNotFound)
+
+	err = col.Delete(2)
+	require.ErrorIs(t, err, DocumentNotFound)
+}
+
+func TestFlatDBCollectionAtomicWrites(t *testing.T) {
+	for _, durability := range []Durability{DurabilityNone, DurabilityFileSync, DurabilityFileAndDirSync} {
+		durability := durability
+		t.Run(fmt.Sprintf("durability %d", durability), func(t *testing.T) {
+			dir := t.TempDir()
+
+			logger, err := zap.NewDevelopment()
+			require.NoError(t, err)
+
+			db, err := NewFlatDB(dir, logger)
+			require.NoError(t, err)
+
+			col, err := NewFlatDBCollection[testData](db, "test-collection", logger, WithDurability[testData](durability))
+			require.NoError(t, err)
+
+			_, err = col.Insert(&testData{Foo: "hello"})
+			require.NoError(t, err)
+
+			err = col.Update(1, &testData{Foo: "world"})
+			require.NoError(t, err)
+
+			res, err := col.GetByID(1)
+			require.NoError(t, err)
+			require.Equal(t, testData{Foo: "world"}, res.Data)
+
+			files, err := os.ReadDir(filepath.Join(dir, "test-collection"))
+			require.NoError(t, err)
+			for _, f := range files {
+				require.False(t, strings.HasSuffix(f.Name(), tempFileSuffix), f.Name())
+			}
+		})
+	}
+
+	t.Run("Init removes leftover temp files", func(t *testing.T) {
+		dir := t.TempDir()
+
+		logger, err := zap.NewDevelopment()
+		require.NoError(t, err)
+
+		db, err := NewFlatDB(dir, logger)
+		require.NoError(t, err)
+
+		colDir := filepath.Join(dir, "test-collection")
+		require.NoError(t, os.MkdirAll(colDir, 0777))
+
+		tempPath := filepath.Join(colDir, ".1.json.123"+tempFileSuffix)
+		require.NoError(t, os.WriteFile(tempPath, []byte(`{"data":{"fo`), 0666))
+
+		col, err := NewFlatDBCollection[testData](db, "test-collection", logger, WithUnorderedIndex[testData]("Foo"))
+		require.NoError(t, err)
+
+		_, err = os.Stat(tempPath)
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		res, err := col.QueryBuilder().Select().Execute()
+		require.NoError(t, err)
+		require.Equal(t, 0, len(res))
+	})
+}
+
+func TestFlatDBCollectionContext(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	db, err := NewFlatDB(dir, logger)
+	require.NoError(t, err)
+
+	col, err := NewFlatDBCollection[testData](db, "test-collection", logger, WithUnorderedIndex[testData]("Foo"))
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		_, err := col.Insert(&testData{Foo: "hello"})
+		require.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = col.InsertContext(ctx, &testData{Foo: "hello"})
+	require.ErrorIs(t, err, context.Canceled)
+
+	_, err = col.GetByIDContext(ctx, 1)
+	require.ErrorIs(t, err, context.Canceled)
+
+	_, err = col.GetByID(11)
+	require.ErrorIs(t, err, DocumentNotFound)
+
+	err = col.UpdateContext(ctx, 1, &testData{Foo: "world"})
+	require.ErrorIs(t, err, context.Canceled)
+
+	_, err = col.PatchContext(ctx, 1, []byte(`{"foo":"world"}`))
+	require.ErrorIs(t, err, context.Canceled)
+
+	err = col.DeleteContext(ctx, 1)
+	require.ErrorIs(t, err, context.Canceled)
+
+	_, err = col.QueryBuilder().Select().ExecuteContext(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+
+	_, err = col.QueryBuilder().Where("Foo", "=", "hello").ExecuteContext(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+
+	deadlineCtx, deadlineCancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer deadlineCancel()
+
+	_, err = col.QueryBuilder().Select().ExecuteContext(deadlineCtx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	col2, err := NewFlatDBCollection[testData](db, "test-collection", logger, WithUnorderedIndex[testData]("Foo"))
+	require.NoError(t, err)
+
+	err = col2.InitContext(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+
+	docs, err := col.QueryBuilder().Where("Foo", "=", "hello").ExecuteContext(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 10, len(docs))
+}
+
 func BenchmarkFlatDBCollection(b *testing.B) {
 	b.Run("Insert", func(b *testing.B) {
 		dir := b.TempDir()
@@ -386,3 +696,66 @@ func BenchmarkFlatDBCollection(b *testing.B) {
 		}
 	})
 }
+
+func TestFlatDBCollectionUniqueIndex(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	db, err := NewFlatDB(dir, logger)
+	require.NoError(t, err)
+
+	col, err := NewFlatDBCollection[testData](db, "test-collection", logger, WithUniqueIndex[testData]("Foo"))
+	require.NoError(t, err)
+
+	_, err = col.Insert(&testData{Foo: "a"})
+	require.NoError(t, err)
+	_, err = col.Insert(&testData{Foo: "b"})
+	require.NoError(t, err)
+
+	var violation *ErrUniqueViolation
+
+	_, err = col.Insert(&testData{Foo: "a"})
+	require.ErrorAs(t, err, &violation)
+	require.Equal(t, ErrUniqueViolation{Field: "Foo", Value: "a", ConflictingID: 1}, *violation)
+
+	// a rejected insert doesn't use up an id
+	res, err := col.Insert(&testData{Foo: "c"})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), res.ID)
+
+	err = col.Update(2, &testData{Foo: "a"})
+	require.ErrorAs(t, err, &violation)
+	require.Equal(t, uint64(1), violation.ConflictingID)
+
+	_, err = col.Patch(3, []byte(`{"foo": "b"}`))
+	require.ErrorAs(t, err, &violation)
+	require.Equal(t, uint64(2), violation.ConflictingID)
+
+	// updating a document to its own value is not a violation
+	err = col.Update(1, &testData{Foo: "a"})
+	require.NoError(t, err)
+
+	err = col.Delete(1)
+	require.NoError(t, err)
+	_, err = col.Insert(&testData{Foo: "a"})
+	require.NoError(t, err)
+
+	t.Run("init reports existing duplicates", func(t *testing.T) {
+		dupCol, err := NewFlatDBCollection[testData](db, "dup-collection", logger)
+		require.NoError(t, err)
+
+		for _, foo := range []string{"x", "y", "x", "x"} {
+			_, err := dupCol.Insert(&testData{Foo: foo})
+			require.NoError(t, err)
+		}
+		require.NoError(t, dupCol.Close())
+
+		_, err = NewFlatDBCollection[testData](db, "dup-collection", logger, WithUniqueIndex[testData]("Foo"))
+		require.ErrorAs(t, err, &violation)
+		require.Equal(t, ErrUniqueViolation{Field: "Foo", Value: "x", ConflictingID: 1}, *violation)
+		require.Contains(t, err.Error(), "document 3")
+		require.Contains(t, err.Error(), "document 4")
+	})
+}
@@ -0,0 +1,107 @@
+package goflatdb
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Durability controls which fsync calls are made when a document is written.
+type Durability uint8
+
+const (
+	// DurabilityNone never calls fsync, a crash may lose recent writes.
+	DurabilityNone Durability = iota
+	// DurabilityFileSync fsyncs the document file before it is renamed into place.
+	DurabilityFileSync
+	// DurabilityFileAndDirSync additionally fsyncs the collection directory after the rename,
+	// so the rename itself survives a crash.
+	DurabilityFileAndDirSync
+)
+
+const tempFileSuffix = ".tmp"
+
+// writeFileAtomic writes data into a temp file in dir and renames it over fileName,
+// so readers never observe a partially written file.
+func writeFileAtomic(dir *os.File, fileName string, data []byte, durability Durability) (err error) {
+	f, err := os.CreateTemp(dir.Name(), "."+fileName+".*"+tempFileSuffix)
+	if err != nil {
+		return errorWritingFileAtomic(fileName, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	bufWriter := bufio.NewWriter(f)
+	if _, err := bufWriter.Write(data); err != nil {
+		return errorWritingFileAtomic(fileName, err)
+	}
+
+	if err := bufWriter.Flush(); err != nil {
+		return errorWritingFileAtomic(fileName, err)
+	}
+
+	if durability >= DurabilityFileSync {
+		if err := f.Sync(); err != nil {
+			return errorWritingFileAtomic(fileName, err)
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		return errorWritingFileAtomic(fileName, err)
+	}
+
+	if err := os.Rename(f.Name(), filepath.Join(dir.Name(), fileName)); err != nil {
+		return errorWritingFileAtomic(fileName, err)
+	}
+
+	if durability >= DurabilityFileAndDirSync {
+		if err := dir.Sync(); err != nil {
+			return errorWritingFileAtomic(fileName, err)
+		}
+	}
+
+	return nil
+}
+
+// removeTempFiles removes temp files left behind by writes interrupted by a crash.
+func removeTempFiles(dir string) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name(), ".") || !strings.HasSuffix(f.Name(), tempFileSuffix) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(dir, f.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// syncDir fsyncs the directory dir, so renames and removals of its files survive a crash.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	return f.Sync()
+}
+
+func errorWritingFileAtomic(fileName string, err error) error {
+	return fmt.Errorf("error writing file %s: %w", fileName, err)
+}
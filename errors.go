@@ -1,5 +1,50 @@
 package goflatdb
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var DocumentNotFound = errors.New("document not found")
+
+// InvalidQuery is returned when a query is malformed, for example when it uses an unknown operator.
+var InvalidQuery = errors.New("invalid query")
+
+// ErrTxDone is returned when a transaction is used after it was committed or rolled back.
+var ErrTxDone = errors.New("transaction has already been committed or rolled back")
+
+// ErrUniqueViolation is returned when a write would give a uniquely indexed field a value
+// that another document already has.
+type ErrUniqueViolation struct {
+	Field         string
+	Value         interface{}
+	ConflictingID uint64
+}
+
+func (e *ErrUniqueViolation) Error() string {
+	return fmt.Sprintf("unique index violation: value %v of field %s is already used by document %d", e.Value, e.Field, e.ConflictingID)
+}
+
+// ErrVersionConflict is returned by UpdateIfVersion when the stored version of a document is not the expected one,
+// because the document was written since it was read.
+type ErrVersionConflict struct {
+	ID       uint64
+	Expected uint64
+	Actual   uint64
+}
+
+func (e *ErrVersionConflict) Error() string {
+	return fmt.Sprintf("version conflict: document %d has version %d, expected %d", e.ID, e.Actual, e.Expected)
+}
+
+// ErrCorruptDocument is returned when the data of a document file doesn't match its checksum,
+// for example because the file was modified outside of the collection or damaged on disk.
+type ErrCorruptDocument struct {
+	ID             uint64
+	Checksum       uint32
+	ActualChecksum uint32
+}
+
+func (e *ErrCorruptDocument) Error() string {
+	return fmt.Sprintf("document %d is corrupt: checksum is %08x, expected %08x", e.ID, e.ActualChecksum, e.Checksum)
+}
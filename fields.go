@@ -0,0 +1,135 @@
+package goflatdb
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// structFieldNames maps a struct type to the field indexes of the names its fields are known by
+// in flatdb and json tags. It is filled lazily, once per type.
+var structFieldNames sync.Map // reflect.Type -> map[string][]int
+
+// resolveStructField returns the field of the struct type t called name. An exported field with the Go name name
+// is used if there is one, otherwise names given by a `flatdb:"name"` tag take precedence over names given
+// by a `json:"name"` tag.
+func resolveStructField(t reflect.Type, name string) (reflect.StructField, bool) {
+	if field, ok := t.FieldByName(name); ok && field.IsExported() {
+		return field, true
+	}
+
+	index, ok := tagFieldNames(t)[name]
+	if !ok {
+		return reflect.StructField{}, false
+	}
+
+	field := t.FieldByIndex(index)
+	// FieldByIndex sets the index of a promoted field relative to its embedded struct
+	field.Index = index
+
+	return field, true
+}
+
+func tagFieldNames(t reflect.Type) map[string][]int {
+	if names, ok := structFieldNames.Load(t); ok {
+		return names.(map[string][]int)
+	}
+
+	// a flatdb tag wins over a json tag, and a shallower field over a promoted one
+	type rank struct {
+		tag   int
+		depth int
+	}
+
+	names := map[string][]int{}
+	ranks := map[string]rank{}
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() {
+			continue
+		}
+
+		for i, tag := range []string{"flatdb", "json"} {
+			name := tagName(field.Tag.Get(tag))
+			if tag == "flatdb" {
+				name, _ = flatdbTag(field.Tag.Get(tag))
+			}
+			if name == "" {
+				continue
+			}
+
+			r := rank{tag: i, depth: len(field.Index)}
+			if old, ok := ranks[name]; ok && (old.tag < r.tag || (old.tag == r.tag && old.depth <= r.depth)) {
+				continue
+			}
+
+			names[name] = field.Index
+			ranks[name] = r
+		}
+	}
+
+	res, _ := structFieldNames.LoadOrStore(t, names)
+
+	return res.(map[string][]int)
+}
+
+// tagName returns the name set by a struct tag value, which is its first comma separated element.
+func tagName(tag string) string {
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "-" {
+		return ""
+	}
+
+	return name
+}
+
+// flatdbTag splits the value of a flatdb tag into the field name and the validation rules following it.
+// The name may be left out, so `flatdb:"required,max=10"` sets rules of a field known by its other names.
+func flatdbTag(tag string) (string, []string) {
+	if tag == "" {
+		return "", nil
+	}
+
+	elems := strings.Split(tag, ",")
+	if isValidationRule(elems[0]) {
+		return "", elems
+	}
+
+	return tagName(elems[0]), elems[1:]
+}
+
+// canonicalPath returns path with the names of struct fields replaced by their Go names, as far as the fields
+// are known from t, so that paths addressing the same field by different names are equal.
+func canonicalPath(t reflect.Type, path fieldPath) fieldPath {
+	res := make(fieldPath, len(path))
+	copy(res, path)
+
+	for i, segment := range path {
+		if t == nil {
+			break
+		}
+
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+
+		switch {
+		case segment.kind == pathSegmentField && t.Kind() == reflect.Struct:
+			field, ok := resolveStructField(t, segment.name)
+			if !ok {
+				t = nil
+				continue
+			}
+
+			res[i].name = field.Name
+			t = field.Type
+		case segment.kind == pathSegmentField && t.Kind() == reflect.Map:
+			t = t.Elem()
+		case segment.kind != pathSegmentField && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array):
+			t = t.Elem()
+		default:
+			t = nil
+		}
+	}
+
+	return res
+}
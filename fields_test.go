@@ -0,0 +1,127 @@
+package goflatdb
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fieldsTestEmbedded struct {
+	Region string `json:"region"`
+	Zone   string `json:"name"`
+}
+
+type fieldsTestData struct {
+	fieldsTestEmbedded
+
+	Name     string `json:"name,omitempty"`
+	Email    string `json:"email" flatdb:"mail"`
+	Nickname string `json:"-"`
+	Plain    int
+	Other    string `json:"Plain"`
+	Address  struct {
+		City string `json:"city"`
+	} `json:"address"`
+}
+
+func TestResolveStructField(t *testing.T) {
+	dataType := reflect.TypeOf(fieldsTestData{})
+
+	testCases := map[string]string{
+		"name":     "Name",
+		"Name":     "Name",
+		"email":    "Email",
+		"mail":     "Email",
+		"Email":    "Email",
+		"region":   "Region",
+		"Region":   "Region",
+		"Nickname": "Nickname",
+		"Plain":    "Plain",
+		"Other":    "Other",
+		"address":  "Address",
+	}
+	for name, expected := range testCases {
+		field, ok := resolveStructField(dataType, name)
+		require.True(t, ok, name)
+		require.Equal(t, expected, field.Name, name)
+	}
+
+	for _, name := range []string{"-", "nickname", "city", "unknown"} {
+		_, ok := resolveStructField(dataType, name)
+		require.False(t, ok, name)
+	}
+
+	// a Go field name wins over a tag naming another field
+	collision := reflect.TypeOf(struct {
+		A string `json:"B"`
+		B string
+	}{})
+	for _, name := range []string{"A", "B"} {
+		field, ok := resolveStructField(collision, name)
+		require.True(t, ok, name)
+		require.Equal(t, name, field.Name)
+	}
+
+	path, err := parsePath(`address.city`)
+	require.NoError(t, err)
+	require.Equal(t, "Address.City", canonicalPath(dataType, path).String())
+}
+
+func TestQueryByTagName(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	db, err := NewFlatDB(dir, logger)
+	require.NoError(t, err)
+
+	col, err := NewFlatDBCollection[fieldsTestData](db, "test-collection", logger,
+		WithUnorderedIndex[fieldsTestData]("email"),
+		WithOrderedIndex[fieldsTestData]("Name"),
+		WithCompositeIndex[fieldsTestData]("region", "address.city"))
+	require.NoError(t, err)
+
+	for _, name := range []string{"bob", "alice", "carol"} {
+		doc := fieldsTestData{Name: name, Email: name + "@example.com"}
+		doc.Region = "eu"
+		doc.Address.City = name + "ville"
+
+		_, err := col.Insert(&doc)
+		require.NoError(t, err)
+	}
+
+	queryIDs := func(t *testing.T, q *QueryBuilder[fieldsTestData]) []uint64 {
+		docs, err := q.Execute()
+		require.NoError(t, err)
+
+		ids := []uint64{}
+		for _, doc := range docs {
+			ids = append(ids, doc.ID)
+		}
+		return ids
+	}
+
+	for _, fieldName := range []string{"email", "mail", "Email"} {
+		require.Equal(t, []uint64{2}, queryIDs(t, col.QueryBuilder().Where(fieldName, "=", "alice@example.com")), fieldName)
+
+		_, indexed, err := col.lookupIndex(fieldName, OperatorEquals, "alice@example.com")
+		require.NoError(t, err)
+		require.True(t, indexed, fieldName)
+	}
+
+	for _, fieldName := range []string{"name", "Name"} {
+		_, indexed, err := col.lookupIndex(fieldName, OperatorMore, "a")
+		require.NoError(t, err)
+		require.True(t, indexed, fieldName)
+
+		require.Equal(t, []uint64{2, 1, 3}, queryIDs(t, col.QueryBuilder().Select().OrderBy(fieldName, Asc)), fieldName)
+	}
+
+	require.Equal(t, []uint64{3}, queryIDs(t, col.QueryBuilder().
+		Where("Region", "=", "eu").
+		And(col.QueryBuilder().Where("Address.City", "=", "carolville"))))
+	require.Equal(t, []uint64{1, 2, 3}, queryIDs(t, col.QueryBuilder().Where("region", "=", "eu")))
+}
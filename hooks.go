@@ -0,0 +1,137 @@
+package goflatdb
+
+import (
+	"context"
+	"fmt"
+)
+
+// collectionHooks are the functions run around the writes to a collection, in the order they were registered.
+//
+// Before-hooks run while the collection is locked for the write, after the document to update or delete
+// was read and before anything is written, so they must not call methods of the collection. An error
+// returned by a before-hook aborts the write. After-hooks run once the write is durable and the collection
+// is unlocked again.
+type collectionHooks[T any] struct {
+	beforeInsert []func(ctx context.Context, data *T) error
+	afterInsert  []func(ctx context.Context, doc FlatDBModel[T])
+	beforeUpdate []func(ctx context.Context, old FlatDBModel[T], data *T) error
+	afterUpdate  []func(ctx context.Context, old FlatDBModel[T], doc FlatDBModel[T])
+	beforeDelete []func(ctx context.Context, doc FlatDBModel[T]) error
+	afterDelete  []func(ctx context.Context, doc FlatDBModel[T])
+}
+
+// OnBeforeInsert registers fn to run before every insert. fn may modify data, an error aborts the insert.
+func (c *FlatDBCollection[T]) OnBeforeInsert(fn func(ctx context.Context, data *T) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.hooks.beforeInsert = append(c.hooks.beforeInsert, fn)
+}
+
+// OnAfterInsert registers fn to run after every insert with the inserted document.
+func (c *FlatDBCollection[T]) OnAfterInsert(fn func(ctx context.Context, doc FlatDBModel[T])) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.hooks.afterInsert = append(c.hooks.afterInsert, fn)
+}
+
+// OnBeforeUpdate registers fn to run before every update and patch with the stored document and its new data.
+// fn may modify data, an error aborts the update.
+func (c *FlatDBCollection[T]) OnBeforeUpdate(fn func(ctx context.Context, old FlatDBModel[T], data *T) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.hooks.beforeUpdate = append(c.hooks.beforeUpdate, fn)
+}
+
+// OnAfterUpdate registers fn to run after every update and patch with the document before and after it.
+func (c *FlatDBCollection[T]) OnAfterUpdate(fn func(ctx context.Context, old FlatDBModel[T], doc FlatDBModel[T])) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.hooks.afterUpdate = append(c.hooks.afterUpdate, fn)
+}
+
+// OnBeforeDelete registers fn to run before every delete with the document to delete. An error aborts the delete.
+func (c *FlatDBCollection[T]) OnBeforeDelete(fn func(ctx context.Context, doc FlatDBModel[T]) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.hooks.beforeDelete = append(c.hooks.beforeDelete, fn)
+}
+
+// OnAfterDelete registers fn to run after every delete with the deleted document.
+func (c *FlatDBCollection[T]) OnAfterDelete(fn func(ctx context.Context, doc FlatDBModel[T])) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.hooks.afterDelete = append(c.hooks.afterDelete, fn)
+}
+
+// runBeforeInsert runs the before-insert hooks. Caller must hold c.mu.
+func (c *FlatDBCollection[T]) runBeforeInsert(ctx context.Context, data *T) error {
+	for _, fn := range c.hooks.beforeInsert {
+		if err := fn(ctx, data); err != nil {
+			return errorRunningHook("before insert", err)
+		}
+	}
+
+	return nil
+}
+
+// runBeforeUpdate runs the before-update hooks. Caller must hold c.mu.
+func (c *FlatDBCollection[T]) runBeforeUpdate(ctx context.Context, old FlatDBModel[T], data *T) error {
+	for _, fn := range c.hooks.beforeUpdate {
+		if err := fn(ctx, old, data); err != nil {
+			return errorRunningHook("before update", err)
+		}
+	}
+
+	return nil
+}
+
+// runBeforeDelete runs the before-delete hooks. Caller must hold c.mu.
+func (c *FlatDBCollection[T]) runBeforeDelete(ctx context.Context, doc FlatDBModel[T]) error {
+	for _, fn := range c.hooks.beforeDelete {
+		if err := fn(ctx, doc); err != nil {
+			return errorRunningHook("before delete", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *FlatDBCollection[T]) runAfterInsert(ctx context.Context, doc FlatDBModel[T]) {
+	c.mu.RLock()
+	hooks := c.hooks.afterInsert
+	c.mu.RUnlock()
+
+	for _, fn := range hooks {
+		fn(ctx, doc)
+	}
+}
+
+func (c *FlatDBCollection[T]) runAfterUpdate(ctx context.Context, old FlatDBModel[T], doc FlatDBModel[T]) {
+	c.mu.RLock()
+	hooks := c.hooks.afterUpdate
+	c.mu.RUnlock()
+
+	for _, fn := range hooks {
+		fn(ctx, old, doc)
+	}
+}
+
+func (c *FlatDBCollection[T]) runAfterDelete(ctx context.Context, doc FlatDBModel[T]) {
+	c.mu.RLock()
+	hooks := c.hooks.afterDelete
+	c.mu.RUnlock()
+
+	for _, fn := range hooks {
+		fn(ctx, doc)
+	}
+}
+
+func errorRunningHook(hook string, err error) error {
+	return fmt.Errorf("error running %s hook: %w", hook, err)
+}
@@ -0,0 +1,132 @@
+package goflatdb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestHooks(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	db, err := NewFlatDB(dir, logger)
+	require.NoError(t, err)
+
+	col, err := NewFlatDBCollection[testData](db, "test-collection", logger, WithUnorderedIndex[testData]("foo"))
+	require.NoError(t, err)
+
+	errRejected := errors.New("rejected")
+	calls := []string{}
+
+	col.OnBeforeInsert(func(ctx context.Context, data *testData) error {
+		calls = append(calls, "before insert "+data.Foo)
+		if data.Foo == "bad" {
+			return errRejected
+		}
+		data.Foo = strings.ToLower(data.Foo)
+		return nil
+	})
+	col.OnAfterInsert(func(ctx context.Context, doc FlatDBModel[testData]) {
+		// after-hooks run once the collection is unlocked and the document is stored
+		stored, err := col.GetByID(doc.ID)
+		require.NoError(t, err)
+		calls = append(calls, "after insert "+stored.Data.Foo)
+	})
+	col.OnBeforeUpdate(func(ctx context.Context, old FlatDBModel[testData], data *testData) error {
+		calls = append(calls, "before update "+old.Data.Foo+" "+data.Foo)
+		if data.Foo == "bad" {
+			return errRejected
+		}
+		return nil
+	})
+	col.OnAfterUpdate(func(ctx context.Context, old FlatDBModel[testData], doc FlatDBModel[testData]) {
+		calls = append(calls, "after update "+old.Data.Foo+" "+doc.Data.Foo)
+	})
+	col.OnBeforeDelete(func(ctx context.Context, doc FlatDBModel[testData]) error {
+		calls = append(calls, "before delete "+doc.Data.Foo)
+		if doc.Data.Foo == "keep" {
+			return errRejected
+		}
+		return nil
+	})
+	col.OnAfterDelete(func(ctx context.Context, doc FlatDBModel[testData]) {
+		calls = append(calls, "after delete "+doc.Data.Foo)
+	})
+
+	res, err := col.Insert(&testData{Foo: "A"})
+	require.NoError(t, err)
+	require.NoError(t, col.Update(res.ID, &testData{Foo: "b"}))
+	_, err = col.Patch(res.ID, []byte(`{"foo": "keep"}`))
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"before insert A", "after insert a",
+		"before update a b", "after update a b",
+		"before update b keep", "after update b keep",
+	}, calls)
+
+	t.Run("before-hook errors abort writes", func(t *testing.T) {
+		calls = nil
+
+		_, err := col.Insert(&testData{Foo: "bad"})
+		require.ErrorIs(t, err, errRejected)
+		require.ErrorIs(t, col.Update(res.ID, &testData{Foo: "bad"}), errRejected)
+		require.ErrorIs(t, col.Delete(res.ID), errRejected)
+
+		doc, err := col.GetByID(res.ID)
+		require.NoError(t, err)
+		require.Equal(t, "keep", doc.Data.Foo)
+
+		found, err := col.QueryBuilder().Where("foo", "=", "bad").Execute()
+		require.NoError(t, err)
+		require.Len(t, found, 0)
+
+		// a rejected insert doesn't use up an id or leave a file behind
+		files, err := os.ReadDir(filepath.Join(dir, "test-collection"))
+		require.NoError(t, err)
+		for _, f := range files {
+			require.NotEqual(t, col.documentFileName(res.ID+1), f.Name())
+		}
+
+		require.Equal(t, []string{"before insert bad", "before update keep bad", "before delete keep"}, calls)
+	})
+
+	t.Run("transactions run hooks", func(t *testing.T) {
+		calls = nil
+
+		tx := db.Begin()
+		inserted, err := col.InsertTx(tx, &testData{Foo: "C"})
+		require.NoError(t, err)
+		require.NoError(t, col.UpdateTx(tx, res.ID, &testData{Foo: "d"}))
+		require.NoError(t, tx.Commit())
+
+		doc, err := col.GetByID(inserted.ID)
+		require.NoError(t, err)
+		require.Equal(t, "c", doc.Data.Foo)
+
+		require.Equal(t, []string{
+			"before insert C", "before update keep d",
+			"after insert c", "after update keep d",
+		}, calls)
+
+		calls = nil
+
+		tx = db.Begin()
+		require.NoError(t, col.DeleteTx(tx, inserted.ID))
+		_, err = col.InsertTx(tx, &testData{Foo: "bad"})
+		require.NoError(t, err)
+		require.ErrorIs(t, tx.Commit(), errRejected)
+
+		_, err = col.GetByID(inserted.ID)
+		require.NoError(t, err)
+		require.Equal(t, []string{"before delete c", "before insert bad"}, calls)
+	})
+}
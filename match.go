@@ -0,0 +1,231 @@
+package goflatdb
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// prepareOperand validates the value passed to Where for operator and converts it to the form matchOperator expects.
+func prepareOperand(operator QueryOperator, operand interface{}) (interface{}, error) {
+	switch operator {
+	case OperatorIn, OperatorNotIn:
+		if !isList(operand) {
+			return nil, fmt.Errorf("%w: operand of in/not in must be a slice or an array, got %T", InvalidQuery, operand)
+		}
+	case OperatorLess, OperatorLessOrEquals, OperatorMore, OperatorMoreOrEquals:
+		if _, err := compareValues(operand, operand); err != nil {
+			return nil, fmt.Errorf("%w: operand of <, <=, >, >= must be a number, string, bool or time.Time, got %T", InvalidQuery, operand)
+		}
+	case OperatorBetween:
+		if !isList(operand) || reflect.ValueOf(operand).Len() != 2 {
+			return nil, fmt.Errorf("%w: operand of between must be a slice or an array of two elements, got %v", InvalidQuery, operand)
+		}
+
+		bounds := reflect.ValueOf(operand)
+		if _, err := compareValues(bounds.Index(0).Interface(), bounds.Index(1).Interface()); err != nil {
+			return nil, fmt.Errorf("%w: bounds of between must be comparable with each other: %w", InvalidQuery, err)
+		}
+	case OperatorPrefix:
+		if _, ok := operand.(string); !ok {
+			return nil, fmt.Errorf("%w: operand of prefix must be a string, got %T", InvalidQuery, operand)
+		}
+	case OperatorRegex:
+		switch v := operand.(type) {
+		case *regexp.Regexp:
+			return v, nil
+		case string:
+			re, err := regexp.Compile(v)
+			if err != nil {
+				return nil, fmt.Errorf("%w: error compiling regex operand: %w", InvalidQuery, err)
+			}
+			return re, nil
+		default:
+			return nil, fmt.Errorf("%w: operand of regex must be a string or *regexp.Regexp, got %T", InvalidQuery, operand)
+		}
+	case OperatorExists, OperatorIsNull:
+		if _, ok := operand.(bool); operand != nil && !ok {
+			return nil, fmt.Errorf("%w: operand of exists/is null must be nil or bool, got %T", InvalidQuery, operand)
+		}
+	}
+
+	return operand, nil
+}
+
+// operatorBounds returns the range of an ordered index that holds all keys matching operator.
+// ok is false if operator can't be answered with a range scan.
+func operatorBounds(operator QueryOperator, operand interface{}) (lower *indexBound, upper *indexBound, ok bool) {
+	switch operator {
+	case OperatorLess:
+		return nil, &indexBound{key: operand}, true
+	case OperatorLessOrEquals:
+		return nil, &indexBound{key: operand, inclusive: true}, true
+	case OperatorMore:
+		return &indexBound{key: operand}, nil, true
+	case OperatorMoreOrEquals:
+		return &indexBound{key: operand, inclusive: true}, nil, true
+	case OperatorBetween:
+		refVal := reflect.ValueOf(operand)
+		return &indexBound{key: refVal.Index(0).Interface(), inclusive: true},
+			&indexBound{key: refVal.Index(1).Interface(), inclusive: true}, true
+	case OperatorPrefix:
+		return &indexBound{key: operand, inclusive: true}, nil, true
+	}
+
+	return nil, nil, false
+}
+
+// matchOperator reports whether field satisfies operator with operand. field is invalid if the document has no such field.
+func matchOperator(operator QueryOperator, field reflect.Value, operand interface{}) (bool, error) {
+	switch operator {
+	case OperatorExists:
+		return field.IsValid() == (operand != false), nil
+	case OperatorIsNull:
+		return isNull(field) == (operand != false), nil
+	}
+
+	if !field.IsValid() || !field.CanInterface() {
+		return false, nil
+	}
+
+	val := field.Interface()
+
+	switch operator {
+	case OperatorEquals:
+		return valuesEqual(val, operand), nil
+	case OperatorNotEquals:
+		return !valuesEqual(val, operand), nil
+	case OperatorLess, OperatorLessOrEquals, OperatorMore, OperatorMoreOrEquals:
+		// values of another kind than the operand, such as nil, don't match
+		cmp, err := compareValues(val, operand)
+		if err != nil {
+			return false, nil
+		}
+
+		switch operator {
+		case OperatorLess:
+			return cmp < 0, nil
+		case OperatorLessOrEquals:
+			return cmp <= 0, nil
+		case OperatorMore:
+			return cmp > 0, nil
+		default:
+			return cmp >= 0, nil
+		}
+	case OperatorIn, OperatorNotIn:
+		in := listContains(reflect.ValueOf(operand), val)
+		return in == (operator == OperatorIn), nil
+	case OperatorBetween:
+		bounds := reflect.ValueOf(operand)
+
+		lower, err := compareValues(val, bounds.Index(0).Interface())
+		if err != nil {
+			return false, nil
+		}
+
+		upper, err := compareValues(val, bounds.Index(1).Interface())
+		if err != nil {
+			return false, nil
+		}
+
+		return lower >= 0 && upper <= 0, nil
+	case OperatorPrefix:
+		if field.Kind() != reflect.String {
+			return false, nil
+		}
+
+		return strings.HasPrefix(field.String(), operand.(string)), nil
+	case OperatorContains:
+		switch {
+		case field.Kind() == reflect.String:
+			substr, ok := operand.(string)
+			if !ok {
+				return false, fmt.Errorf("operand of contains on a string field must be a string, got %T", operand)
+			}
+			return strings.Contains(field.String(), substr), nil
+		case isList(val):
+			return listContains(field, operand), nil
+		default:
+			return false, nil
+		}
+	case OperatorRegex:
+		if field.Kind() != reflect.String {
+			return false, nil
+		}
+
+		return operand.(*regexp.Regexp).MatchString(field.String()), nil
+	}
+
+	return false, fmt.Errorf("unsupported operator %d", operator)
+}
+
+// matchValues reports whether the values a path resolves to satisfy operator with operand. A path resolving
+// to no values is matched like a missing field. Otherwise "!=" and "not in" require every value to match,
+// and all other operators at least one.
+func matchValues(operator QueryOperator, values []reflect.Value, operand interface{}) (bool, error) {
+	if len(values) == 0 {
+		return matchOperator(operator, reflect.Value{}, operand)
+	}
+
+	// slices match by their elements as well, like they are indexed. Operators testing the field itself
+	// and contains, which tests the elements of a slice, are left as they are.
+	if operator != OperatorExists && operator != OperatorIsNull && operator != OperatorContains {
+		values = expandLists(values)
+	}
+
+	negated := operator == OperatorNotEquals || operator == OperatorNotIn
+	for _, v := range values {
+		ok, err := matchOperator(operator, v, operand)
+		if err != nil {
+			return false, err
+		}
+
+		if ok != negated {
+			return ok, nil
+		}
+	}
+
+	return negated, nil
+}
+
+// valuesEqual compares comparable values with compareValues and everything else with reflect.DeepEqual.
+func valuesEqual(a interface{}, b interface{}) bool {
+	if cmp, err := compareValues(a, b); err == nil {
+		return cmp == 0
+	}
+
+	return reflect.DeepEqual(normalizeValue(a), normalizeValue(b))
+}
+
+func isNull(field reflect.Value) bool {
+	if !field.IsValid() {
+		return true
+	}
+
+	switch field.Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice:
+		return field.IsNil()
+	}
+
+	return false
+}
+
+func isList(v interface{}) bool {
+	refVal := reflect.ValueOf(v)
+	if !refVal.IsValid() {
+		return false
+	}
+
+	return refVal.Kind() == reflect.Slice || refVal.Kind() == reflect.Array
+}
+
+func listContains(list reflect.Value, v interface{}) bool {
+	for i := 0; i < list.Len(); i++ {
+		if valuesEqual(list.Index(i).Interface(), v) {
+			return true
+		}
+	}
+
+	return false
+}
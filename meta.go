@@ -0,0 +1,127 @@
+package goflatdb
+
+import (
+	"encoding/json"
+	"hash/crc32"
+	"reflect"
+	"time"
+)
+
+// Names of the metadata fields of a document in query, order and index paths. They are prefixed with $,
+// so they don't collide with the fields of the document data.
+const (
+	MetaFieldID        = "$id"
+	MetaFieldVersion   = "$version"
+	MetaFieldCreatedAt = "$createdAt"
+	MetaFieldUpdatedAt = "$updatedAt"
+)
+
+// dataValue returns the document data as the root of paths not addressing a metadata field.
+func (m FlatDBModel[T]) dataValue() reflect.Value {
+	return reflect.ValueOf(m.Data)
+}
+
+// metaValue returns the metadata field called name.
+func (m FlatDBModel[T]) metaValue(name string) (reflect.Value, bool) {
+	switch name {
+	case MetaFieldID:
+		return reflect.ValueOf(m.ID), true
+	case MetaFieldVersion:
+		return reflect.ValueOf(m.Version), true
+	case MetaFieldCreatedAt:
+		return reflect.ValueOf(m.CreatedAt), true
+	case MetaFieldUpdatedAt:
+		return reflect.ValueOf(m.UpdatedAt), true
+	default:
+		return reflect.Value{}, false
+	}
+}
+
+// documentFields is implemented by FlatDBModel, so paths are resolved against its metadata and data.
+type documentFields interface {
+	dataValue() reflect.Value
+	metaValue(name string) (reflect.Value, bool)
+}
+
+// touch sets the timestamps of m for a write replacing prev, which is nil for inserts.
+func (m *FlatDBModel[T]) touch(prev *FlatDBModel[T]) {
+	now := time.Now().UTC()
+
+	m.CreatedAt = now
+	if prev != nil {
+		m.CreatedAt = prev.CreatedAt
+	}
+	m.UpdatedAt = now
+}
+
+// encodeDocument encodes model as the contents of its document file and sets its checksum.
+func (c *FlatDBCollection[T]) encodeDocument(model *FlatDBModel[T]) ([]byte, error) {
+	data, err := c.codec.Marshal(model.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.codec == JSONCodec {
+		// json.Marshal escapes raw messages, marshaling data once more gives the exact bytes stored in the file
+		data, err = json.Marshal(json.RawMessage(data))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	model.Checksum = crc32.ChecksumIEEE(data)
+	model.SchemaVersion = c.schemaVersion()
+
+	// the data is embedded as it is, it is a JSON value in JSON files and a byte string in the other codecs
+	return c.codec.Marshal(FlatDBModel[json.RawMessage]{
+		Data:          data,
+		ID:            model.ID,
+		Version:       model.Version,
+		CreatedAt:     model.CreatedAt,
+		UpdatedAt:     model.UpdatedAt,
+		Checksum:      model.Checksum,
+		SchemaVersion: model.SchemaVersion,
+	})
+}
+
+// decodeDocument decodes the contents of a document file, migrating its data to the latest schema version.
+// It returns ErrCorruptDocument if the data doesn't match its checksum. Documents written without a checksum
+// are not verified.
+func (c *FlatDBCollection[T]) decodeDocument(bytes []byte) (FlatDBModel[T], error) {
+	raw := FlatDBModel[json.RawMessage]{}
+	if err := c.codec.Unmarshal(bytes, &raw); err != nil {
+		return FlatDBModel[T]{}, err
+	}
+
+	if raw.Checksum != 0 {
+		if checksum := crc32.ChecksumIEEE(raw.Data); checksum != raw.Checksum {
+			return FlatDBModel[T]{}, &ErrCorruptDocument{ID: raw.ID, Checksum: raw.Checksum, ActualChecksum: checksum}
+		}
+	}
+
+	if raw.SchemaVersion == 0 {
+		raw.SchemaVersion = 1
+	}
+
+	data, err := c.migrate(raw.Data, raw.SchemaVersion)
+	if err != nil {
+		return FlatDBModel[T]{}, err
+	}
+	raw.Data = data
+
+	result := FlatDBModel[T]{
+		ID:            raw.ID,
+		Version:       raw.Version,
+		CreatedAt:     raw.CreatedAt.UTC(),
+		UpdatedAt:     raw.UpdatedAt.UTC(),
+		Checksum:      raw.Checksum,
+		SchemaVersion: raw.SchemaVersion,
+	}
+	if len(raw.Data) > 0 {
+		if err := c.codec.Unmarshal(raw.Data, &result.Data); err != nil {
+			return FlatDBModel[T]{}, err
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,92 @@
+package goflatdb
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestDocumentMetadata(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	db, err := NewFlatDB(dir, logger)
+	require.NoError(t, err)
+
+	col, err := NewFlatDBCollection[testData](db, "test-collection", logger, WithOrderedIndex[testData](MetaFieldUpdatedAt))
+	require.NoError(t, err)
+
+	before := time.Now().UTC()
+	for _, foo := range []string{"a", "b", "c"} {
+		_, err := col.Insert(&testData{Foo: foo})
+		require.NoError(t, err)
+	}
+	after := time.Now().UTC()
+
+	doc, err := col.GetByID(1)
+	require.NoError(t, err)
+	require.False(t, doc.CreatedAt.Before(before))
+	require.False(t, doc.CreatedAt.After(after))
+	require.Equal(t, doc.CreatedAt, doc.UpdatedAt)
+	require.NotZero(t, doc.Checksum)
+
+	require.NoError(t, col.Update(1, &testData{Foo: "d"}))
+
+	updated, err := col.GetByID(1)
+	require.NoError(t, err)
+	require.Equal(t, doc.CreatedAt, updated.CreatedAt)
+	require.True(t, updated.UpdatedAt.After(doc.UpdatedAt))
+	require.NotEqual(t, doc.Checksum, updated.Checksum)
+
+	queryIDs := func(t *testing.T, q *QueryBuilder[testData]) []uint64 {
+		docs, err := q.Execute()
+		require.NoError(t, err)
+
+		ids := []uint64{}
+		for _, doc := range docs {
+			ids = append(ids, doc.ID)
+		}
+		return ids
+	}
+
+	require.Equal(t, []uint64{1}, queryIDs(t, col.QueryBuilder().Where(MetaFieldUpdatedAt, ">", after)))
+	require.Equal(t, []uint64{1}, queryIDs(t, col.QueryBuilder().Where(MetaFieldVersion, "=", 2)))
+	require.Equal(t, []uint64{2, 3}, queryIDs(t, col.QueryBuilder().Where(MetaFieldCreatedAt, "<=", after).
+		And(col.QueryBuilder().Where("foo", "!=", "d"))))
+	require.Equal(t, []uint64{1, 3, 2}, queryIDs(t, col.QueryBuilder().Select().OrderBy(MetaFieldUpdatedAt, Desc)))
+	require.Equal(t, []uint64{3}, queryIDs(t, col.QueryBuilder().Where(MetaFieldID, "=", 3)))
+
+	_, indexed, err := col.lookupIndex(MetaFieldUpdatedAt, OperatorMore, after)
+	require.NoError(t, err)
+	require.True(t, indexed)
+
+	t.Run("checksum mismatch is reported", func(t *testing.T) {
+		path := filepath.Join(dir, "test-collection", col.documentFileName(2))
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, []byte(strings.Replace(string(content), `"foo":"b"`, `"foo":"x"`, 1)), 0666))
+
+		var corrupt *ErrCorruptDocument
+		_, err = col.GetByID(2)
+		require.ErrorAs(t, err, &corrupt)
+		require.Equal(t, uint64(2), corrupt.ID)
+		require.NotEqual(t, corrupt.Checksum, corrupt.ActualChecksum)
+	})
+
+	t.Run("documents without a checksum are read", func(t *testing.T) {
+		path := filepath.Join(dir, "test-collection", col.documentFileName(4))
+		require.NoError(t, os.WriteFile(path, []byte(`{"data":{"foo":"old"},"ID":4}`), 0666))
+
+		doc, err := col.GetByID(4)
+		require.NoError(t, err)
+		require.Equal(t, testData{Foo: "old"}, doc.Data)
+		require.True(t, doc.CreatedAt.IsZero())
+	})
+}
@@ -0,0 +1,251 @@
+package goflatdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const migrationCheckpointFileName = "migration.checkpoint"
+
+// migrationCheckpointInterval is the number of documents Migrate checks between two checkpoints.
+const migrationCheckpointInterval = 100
+
+// Migration upgrades the data of a document from the previous schema version. data is the decoded object,
+// with numbers decoded as json.Number when the collection uses JSONCodec, and is changed in place.
+type Migration func(data map[string]interface{}) error
+
+// schemaVersion returns the latest schema version, which documents are written with.
+func (c *FlatDBCollection[T]) schemaVersion() uint64 {
+	return uint64(len(c.migrations)) + 1
+}
+
+// checkMigrations returns an error unless the migrations upgrade version 1 step by step to the latest version
+// and the codec of the collection can decode documents into the maps migrations change.
+func (c *FlatDBCollection[T]) checkMigrations() error {
+	if len(c.migrations) > 0 && c.codec == GobCodec {
+		return fmt.Errorf("codec %s doesn't support migrations", c.codec.Name())
+	}
+
+	for version := uint64(2); version <= c.schemaVersion(); version++ {
+		if _, ok := c.migrations[version]; !ok {
+			return fmt.Errorf("missing migration to schema version %d", version)
+		}
+	}
+
+	return nil
+}
+
+// migrate runs the migrations of data, which was stored with schema version version, and returns the migrated data.
+func (c *FlatDBCollection[T]) migrate(data json.RawMessage, version uint64) (json.RawMessage, error) {
+	if version > c.schemaVersion() {
+		return nil, errorMigratingDocument(version, fmt.Errorf("latest known schema version is %d", c.schemaVersion()))
+	}
+
+	if version == c.schemaVersion() {
+		return data, nil
+	}
+
+	var fields map[string]interface{}
+	if c.codec == JSONCodec {
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		if err := d.Decode(&fields); err != nil {
+			return nil, errorMigratingDocument(version, err)
+		}
+	} else if err := c.codec.Unmarshal(data, &fields); err != nil {
+		return nil, errorMigratingDocument(version, err)
+	}
+	if fields == nil {
+		fields = map[string]interface{}{}
+	}
+
+	for v := version + 1; v <= c.schemaVersion(); v++ {
+		if err := c.migrations[v](fields); err != nil {
+			return nil, errorMigratingDocument(version, fmt.Errorf("migration to version %d: %w", v, err))
+		}
+	}
+
+	migrated, err := c.codec.Marshal(fields)
+	if err != nil {
+		return nil, errorMigratingDocument(version, err)
+	}
+
+	return migrated, nil
+}
+
+// MigrationProgress reports how far Migrate got.
+type MigrationProgress struct {
+	Total    int    // documents in the collection when Migrate started
+	Done     int    // documents checked so far, including those checked by an interrupted earlier run
+	Migrated int    // documents rewritten by this run
+	LastID   uint64 // id of the last checked document
+}
+
+// MigrateOption configures Migrate.
+type MigrateOption func(o *migrateOptions)
+
+type migrateOptions struct {
+	progress func(p MigrationProgress)
+}
+
+// MigrateProgress makes Migrate call fn after every document it checks.
+func MigrateProgress(fn func(p MigrationProgress)) MigrateOption {
+	return func(o *migrateOptions) {
+		o.progress = fn
+	}
+}
+
+// migrationCheckpoint is the point an interrupted Migrate resumes from.
+type migrationCheckpoint struct {
+	SchemaVersion uint64 `json:"schemaVersion"`
+	LastID        uint64 `json:"lastID"`
+}
+
+// Migrate rewrites every document stored with an older schema version with its data migrated to the latest one,
+// so the migrations no longer run when the documents are read. Documents are migrated one at a time in order
+// of their ids, while the collection stays available for reads and writes.
+//
+// Migrate saves its progress in the collection directory, so after it was interrupted, by a canceled context
+// or a crash, calling it again resumes with the first document it hadn't checked.
+func (c *FlatDBCollection[T]) Migrate(ctx context.Context, opts ...MigrateOption) error {
+	o := migrateOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	checkpoint, err := c.readMigrationCheckpoint()
+	if err != nil {
+		return errorMigratingCollection(c.name, err)
+	}
+
+	c.mu.RLock()
+	fileNames, err := c.documentFileNames()
+	c.mu.RUnlock()
+	if err != nil {
+		return errorMigratingCollection(c.name, err)
+	}
+
+	progress := MigrationProgress{Total: len(fileNames), LastID: checkpoint.LastID}
+
+	for i, fileName := range fileNames {
+		id := documentIDFromFileName(fileName)
+		if id <= checkpoint.LastID {
+			progress.Done++
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return errorMigratingCollection(c.name, c.saveMigrationCheckpoint(checkpoint, err))
+		}
+
+		migrated, err := c.migrateDocument(id)
+		if err != nil {
+			return errorMigratingCollection(c.name, c.saveMigrationCheckpoint(checkpoint, err))
+		}
+
+		checkpoint.LastID = id
+		progress.Done++
+		progress.LastID = id
+		if migrated {
+			progress.Migrated++
+		}
+
+		if o.progress != nil {
+			o.progress(progress)
+		}
+
+		if (i+1)%migrationCheckpointInterval == 0 {
+			if err := c.saveMigrationCheckpoint(checkpoint, nil); err != nil {
+				return errorMigratingCollection(c.name, err)
+			}
+		}
+	}
+
+	err = os.Remove(documentFilePath(c.dir.Name(), migrationCheckpointFileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errorMigratingCollection(c.name, err)
+	}
+
+	return nil
+}
+
+// migrateDocument rewrites document id if it is stored with an older schema version and reports whether it did.
+// The version and timestamps of the document are kept, as its data only changes shape.
+func (c *FlatDBCollection[T]) migrateDocument(id uint64) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	doc, err := c.readDocument(documentFilePath(c.dir.Name(), c.documentFileName(id)))
+	if errors.Is(err, DocumentNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if doc.SchemaVersion == c.schemaVersion() {
+		return false, nil
+	}
+
+	// the data was migrated when it was read, so the indexes and the index snapshot already hold its keys
+	data, err := c.encodeDocument(&doc)
+	if err != nil {
+		return false, err
+	}
+
+	err = c.applyIntent(walRecord{Op: walOpUpdate, ID: id, Data: data}, func() error {
+		return c.writeDocument(data, id)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// readMigrationCheckpoint returns the checkpoint of an interrupted Migrate, or a checkpoint to start from the first
+// document if there is none or it was saved for another schema version.
+func (c *FlatDBCollection[T]) readMigrationCheckpoint() (migrationCheckpoint, error) {
+	start := migrationCheckpoint{SchemaVersion: c.schemaVersion()}
+
+	data, err := os.ReadFile(documentFilePath(c.dir.Name(), migrationCheckpointFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return start, nil
+	}
+	if err != nil {
+		return migrationCheckpoint{}, err
+	}
+
+	checkpoint := migrationCheckpoint{}
+	if err := json.Unmarshal(data, &checkpoint); err != nil || checkpoint.SchemaVersion != c.schemaVersion() {
+		return start, nil
+	}
+
+	return checkpoint, nil
+}
+
+// saveMigrationCheckpoint saves checkpoint and returns cause, or the error saving the checkpoint if there is no cause.
+func (c *FlatDBCollection[T]) saveMigrationCheckpoint(checkpoint migrationCheckpoint, cause error) error {
+	data, err := json.Marshal(checkpoint)
+	if err == nil {
+		err = writeFileAtomic(c.dir, migrationCheckpointFileName, data, c.durability)
+	}
+
+	if cause != nil {
+		return cause
+	}
+
+	return err
+}
+
+func errorMigratingDocument(version uint64, err error) error {
+	return fmt.Errorf("error migrating document from schema version %d: %w", version, err)
+}
+
+func errorMigratingCollection(collection string, err error) error {
+	return fmt.Errorf("error migrating collection %s: %w", collection, err)
+}
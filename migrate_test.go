@@ -0,0 +1,192 @@
+package goflatdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type migrateTestDataV1 struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+type migrateTestData struct {
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Age       int    `json:"age"`
+	Adult     bool   `json:"adult"`
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	db, err := NewFlatDB(dir, logger)
+	require.NoError(t, err)
+
+	old, err := NewFlatDBCollection[migrateTestDataV1](db, "test-collection", logger)
+	require.NoError(t, err)
+
+	for _, name := range []string{"Ada Lovelace", "Alan Turing", "Grace Hopper", "Edsger Dijkstra"} {
+		_, err := old.Insert(&migrateTestDataV1{Name: name, Age: 36})
+		require.NoError(t, err)
+	}
+	require.NoError(t, old.Close())
+
+	// version 2 splits the name, version 3 adds a field derived from the age
+	open := func(t *testing.T) *FlatDBCollection[migrateTestData] {
+		col, err := NewFlatDBCollection[migrateTestData](db, "test-collection", logger,
+			WithOrderedIndex[migrateTestData]("lastName"),
+			WithMigration[migrateTestData](2, func(data map[string]interface{}) error {
+				first, last, _ := strings.Cut(data["name"].(string), " ")
+				data["firstName"], data["lastName"] = first, last
+				delete(data, "name")
+				return nil
+			}),
+			WithMigration[migrateTestData](3, func(data map[string]interface{}) error {
+				age, err := data["age"].(json.Number).Int64()
+				data["adult"] = age >= 18
+				return err
+			}),
+		)
+		require.NoError(t, err)
+		return col
+	}
+
+	col := open(t)
+
+	doc, err := col.GetByID(2)
+	require.NoError(t, err)
+	require.Equal(t, migrateTestData{FirstName: "Alan", LastName: "Turing", Age: 36, Adult: true}, doc.Data)
+	require.Equal(t, uint64(1), doc.SchemaVersion)
+
+	// indexes are built from the migrated data
+	found, err := col.QueryBuilder().Where("lastName", "=", "Hopper").Execute()
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	require.Equal(t, uint64(3), found[0].ID)
+
+	res, err := col.Insert(&migrateTestData{FirstName: "Barbara", LastName: "Liskov", Age: 40, Adult: true})
+	require.NoError(t, err)
+
+	doc, err = col.GetByID(res.ID)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), doc.SchemaVersion)
+
+	t.Run("migrate resumes where it was interrupted", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		err := col.Migrate(ctx, MigrateProgress(func(p MigrationProgress) {
+			if p.Done == 2 {
+				cancel()
+			}
+		}))
+		require.ErrorIs(t, err, context.Canceled)
+
+		_, err = os.Stat(filepath.Join(dir, "test-collection", migrationCheckpointFileName))
+		require.NoError(t, err)
+
+		progress := []MigrationProgress{}
+		require.NoError(t, col.Migrate(context.Background(), MigrateProgress(func(p MigrationProgress) {
+			progress = append(progress, p)
+		})))
+		require.Equal(t, []MigrationProgress{
+			{Total: 5, Done: 3, Migrated: 1, LastID: 3},
+			{Total: 5, Done: 4, Migrated: 2, LastID: 4},
+			{Total: 5, Done: 5, Migrated: 2, LastID: 5},
+		}, progress)
+
+		_, err = os.Stat(filepath.Join(dir, "test-collection", migrationCheckpointFileName))
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		for id := uint64(1); id <= 4; id++ {
+			doc, err := col.GetByID(id)
+			require.NoError(t, err)
+			require.Equal(t, uint64(3), doc.SchemaVersion)
+			require.Equal(t, uint64(1), doc.Version)
+			require.True(t, doc.Data.Adult)
+		}
+	})
+
+	t.Run("migrations must cover every version", func(t *testing.T) {
+		_, err := NewFlatDBCollection[migrateTestData](db, "other-collection", logger,
+			WithMigration[migrateTestData](3, func(data map[string]interface{}) error { return nil }),
+		)
+		require.Error(t, err)
+	})
+
+	t.Run("failing migrations fail reads", func(t *testing.T) {
+		errBroken := errors.New("broken")
+
+		old, err := NewFlatDBCollection[migrateTestDataV1](db, "broken-collection", logger)
+		require.NoError(t, err)
+		res, err := old.Insert(&migrateTestDataV1{Name: "Ada"})
+		require.NoError(t, err)
+		require.NoError(t, old.Close())
+
+		broken, err := NewFlatDBCollection[migrateTestData](db, "broken-collection", logger,
+			WithMigration[migrateTestData](2, func(data map[string]interface{}) error { return errBroken }),
+		)
+		require.NoError(t, err)
+
+		_, err = broken.GetByID(res.ID)
+		require.ErrorIs(t, err, errBroken)
+		require.ErrorIs(t, broken.Migrate(context.Background()), errBroken)
+	})
+
+	t.Run("newer schema versions can't be read", func(t *testing.T) {
+		require.NoError(t, col.Close())
+
+		old, err := NewFlatDBCollection[migrateTestDataV1](db, "test-collection", logger)
+		require.NoError(t, err)
+
+		_, err = old.GetByID(1)
+		require.Error(t, err)
+	})
+}
+
+func TestMigrateIndexSnapshot(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	db, err := NewFlatDB(dir, logger)
+	require.NoError(t, err)
+
+	old, err := NewFlatDBCollection[migrateTestDataV1](db, "test-collection", logger, WithUnorderedIndex[migrateTestDataV1]("name"))
+	require.NoError(t, err)
+	_, err = old.Insert(&migrateTestDataV1{Name: "Alice"})
+	require.NoError(t, err)
+	require.NoError(t, old.Close())
+	require.FileExists(t, filepath.Join(dir, "test-collection", indexSnapshotFileName))
+
+	// the snapshot holds the keys of version 1, the indexes are rebuilt from the migrated documents
+	col, err := NewFlatDBCollection[migrateTestDataV1](db, "test-collection", logger,
+		WithUnorderedIndex[migrateTestDataV1]("name"),
+		WithMigration[migrateTestDataV1](2, func(data map[string]interface{}) error {
+			data["name"] = strings.ToLower(data["name"].(string))
+			return nil
+		}),
+	)
+	require.NoError(t, err)
+
+	found, err := col.QueryBuilder().Where("name", "=", "alice").Execute()
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+
+	found, err = col.QueryBuilder().Where("name", "=", "Alice").Execute()
+	require.NoError(t, err)
+	require.Len(t, found, 0)
+}
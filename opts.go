@@ -3,10 +3,101 @@ package goflatdb
 func WithUnorderedIndex[T any](fieldName string) FlatDBCollectionOption[T] {
 	return func(db *FlatDBCollection[T]) {
 		db.unorderedIndexes[fieldName] = &flatDBIndexUnorderedIndex{
-			ordered:   false,
 			fieldName: fieldName,
 
 			data: map[interface{}][]string{},
 		}
 	}
 }
+
+// WithUniqueIndex indexes fieldName like WithUnorderedIndex and makes Insert and Update fail with
+// ErrUniqueViolation when another document already has the same value of fieldName.
+func WithUniqueIndex[T any](fieldName string) FlatDBCollectionOption[T] {
+	return func(db *FlatDBCollection[T]) {
+		db.unorderedIndexes[fieldName] = &flatDBIndexUnorderedIndex{
+			fieldName: fieldName,
+			unique:    true,
+
+			data: map[interface{}][]string{},
+		}
+	}
+}
+
+// WithOrderedIndex indexes fieldName in a sorted structure, which backs range queries such as < and >.
+func WithOrderedIndex[T any](fieldName string) FlatDBCollectionOption[T] {
+	return func(db *FlatDBCollection[T]) {
+		db.orderedIndexes[fieldName] = newFlatDBOrderedIndex(fieldName)
+	}
+}
+
+// WithCompositeIndex indexes the values of fieldNames together. And queries whose equality conditions
+// cover a prefix of fieldNames are answered with a single lookup in it.
+func WithCompositeIndex[T any](fieldNames ...string) FlatDBCollectionOption[T] {
+	return func(db *FlatDBCollection[T]) {
+		fieldNames = append([]string(nil), fieldNames...)
+		db.compositeIndexes[compositeIndexName(fieldNames)] = newFlatDBCompositeIndex(fieldNames)
+	}
+}
+
+// WithDurability sets which fsync calls are made on writes. Defaults to DurabilityFileAndDirSync.
+func WithDurability[T any](durability Durability) FlatDBCollectionOption[T] {
+	return func(db *FlatDBCollection[T]) {
+		db.durability = durability
+	}
+}
+
+// WithChangeLog makes the collection record every write in a persisted change log, which backs Watch.
+// It enables the write-ahead log as well, so the events of writes interrupted by a crash are recorded by Init.
+func WithChangeLog[T any]() FlatDBCollectionOption[T] {
+	return func(db *FlatDBCollection[T]) {
+		db.changeLogEnabled = true
+	}
+}
+
+// WithWriteAheadLog makes the collection log every write before applying it, so writes interrupted
+// by a crash are replayed by Init.
+func WithWriteAheadLog[T any]() FlatDBCollectionOption[T] {
+	return func(db *FlatDBCollection[T]) {
+		db.walEnabled = true
+	}
+}
+
+// WithValidation makes Insert and Update reject documents failing the validation rules of their flatdb tags,
+// or their Validate method, with a ValidationError. Rules follow the field name in the tag, which may be
+// left out, as in `flatdb:"required,min=0,max=100,enum=a|b"`:
+//   - required fails for zero values, and empty strings, slices and maps.
+//   - min and max bound numbers, and the length of strings, slices and maps.
+//   - enum lists the allowed values of non-empty fields.
+func WithValidation[T any]() FlatDBCollectionOption[T] {
+	return func(db *FlatDBCollection[T]) {
+		db.validationEnabled = true
+	}
+}
+
+// WithMigration registers migrate as the upgrade of documents to schema version version from the version before it.
+// Migrations must cover every version from 2 to the latest one, documents are written with the latest version
+// and the data of older documents is migrated when they are read, see Migrate.
+func WithMigration[T any](version uint64, migrate Migration) FlatDBCollectionOption[T] {
+	return func(db *FlatDBCollection[T]) {
+		db.migrations[version] = migrate
+	}
+}
+
+// WithCodec sets how documents are encoded in their files. Defaults to JSONCodec. The codec is recorded
+// when the collection is created, opening it with another codec fails with ErrCodecMismatch.
+func WithCodec[T any](codec Codec) FlatDBCollectionOption[T] {
+	return func(db *FlatDBCollection[T]) {
+		db.codec = codec
+	}
+}
+
+// WithCompression compresses document files of at least minSize bytes with compression at level, 0 selecting
+// the default level of the algorithm. Every file records how it is compressed, so documents written before
+// compression was enabled, or with another algorithm, stay readable.
+func WithCompression[T any](compression Compression, level int, minSize int) FlatDBCollectionOption[T] {
+	return func(db *FlatDBCollection[T]) {
+		db.compression = compression
+		db.compressionLevel = level
+		db.compressionMinSize = minSize
+	}
+}
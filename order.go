@@ -0,0 +1,195 @@
+package goflatdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+type SortDirection uint8
+
+const (
+	Asc SortDirection = iota
+	Desc
+)
+
+type orderKey struct {
+	fieldName string
+	path      fieldPath
+	direction SortDirection
+}
+
+// sortEntry holds the sort key values of a single document, so documents can be sorted without keeping them in memory.
+type sortEntry struct {
+	id     uint64
+	values []interface{}
+}
+
+// orderedCursor returns a cursor over the documents of cur ordered by keys, ties are broken by id.
+// Only the sort keys of every document are kept in memory, documents are read again once they are sorted.
+func (c *FlatDBCollection[T]) orderedCursor(ctx context.Context, cur Cursor[T], keys []orderKey) (Cursor[T], error) {
+	defer func() {
+		_ = cur.Close()
+	}()
+
+	entries := []sortEntry{}
+	for cur.Next() {
+		doc := cur.Doc()
+		entries = append(entries, sortEntry{id: doc.ID, values: sortKeyValues(doc, keys)})
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, errorOrderingDocuments(err)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return compareSortEntries(entries[i], entries[j], keys) < 0
+	})
+
+	fileNames := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		fileNames = append(fileNames, c.documentFileName(entry.id))
+	}
+
+	return &documentCursor[T]{
+		ctx:       ctx,
+		col:       c,
+		fileNames: fileNames,
+	}, nil
+}
+
+// orderedIndexGroups returns the file names of all documents grouped by their value of key.fieldName,
+// with groups in key.direction order. ok is false if there is no ordered index on key.fieldName,
+// or if not every document is guaranteed to be in it.
+func (c *FlatDBCollection[T]) orderedIndexGroups(key orderKey) (groups [][]string, ok bool) {
+	if len(key.path) != 1 || key.path[0].kind != pathSegmentField || dataType[T]().Kind() != reflect.Struct {
+		return nil, false
+	}
+	field, ok := resolveStructField(dataType[T](), key.path[0].name)
+	if !ok || !isIndexedOnce(dataType[T](), field) {
+		return nil, false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	idx := c.orderedIndexes[c.indexName(key.fieldName)]
+	if idx == nil {
+		return nil, false
+	}
+
+	idx.scan(nil, nil, func(_ interface{}, fileNames []string) bool {
+		groups = append(groups, append([]string(nil), fileNames...))
+		return true
+	})
+
+	if key.direction == Desc {
+		for i, j := 0, len(groups)-1; i < j; i, j = i+1, j-1 {
+			groups[i], groups[j] = groups[j], groups[i]
+		}
+	}
+
+	return groups, true
+}
+
+// isIndexedOnce reports whether every document has exactly one key in an index on field of the struct type t.
+// Fields of slices, maps and other non-scalar types are indexed by any number of keys, and promoted fields
+// of nil embedded pointers by none.
+func isIndexedOnce(t reflect.Type, field reflect.StructField) bool {
+	for i := 1; i < len(field.Index); i++ {
+		if t.FieldByIndex(field.Index[:i]).Type.Kind() == reflect.Pointer {
+			return false
+		}
+	}
+
+	fieldType := field.Type
+	for fieldType.Kind() == reflect.Pointer {
+		fieldType = fieldType.Elem()
+	}
+
+	kind := fieldType.Kind()
+	return fieldType == timeType || kind == reflect.Bool || kind == reflect.String ||
+		isIntKind(kind) || isUintKind(kind) || isFloatKind(kind)
+}
+
+// readDocuments reads the documents stored in fileNames in order, skipping the ones deleted in the meantime.
+func (c *FlatDBCollection[T]) readDocuments(fileNames []string) ([]FlatDBModel[T], error) {
+	res := make([]FlatDBModel[T], 0, len(fileNames))
+	for _, fileName := range fileNames {
+		doc, err := c.readDocument(documentFilePath(c.dir.Name(), fileName))
+		if err != nil {
+			if errors.Is(err, DocumentNotFound) {
+				continue
+			}
+			return nil, err
+		}
+
+		res = append(res, doc)
+	}
+
+	return res, nil
+}
+
+// sortDocuments sorts docs by keys, ties are broken by id.
+func sortDocuments[T any](docs []FlatDBModel[T], keys []orderKey) {
+	entries := make([]sortEntry, len(docs))
+	for i, doc := range docs {
+		entries[i] = sortEntry{id: doc.ID, values: sortKeyValues(doc, keys)}
+	}
+
+	sort.Sort(documentSorter[T]{docs: docs, entries: entries, keys: keys})
+}
+
+type documentSorter[T any] struct {
+	docs    []FlatDBModel[T]
+	entries []sortEntry
+	keys    []orderKey
+}
+
+func (s documentSorter[T]) Len() int {
+	return len(s.docs)
+}
+
+func (s documentSorter[T]) Less(i, j int) bool {
+	return compareSortEntries(s.entries[i], s.entries[j], s.keys) < 0
+}
+
+func (s documentSorter[T]) Swap(i, j int) {
+	s.docs[i], s.docs[j] = s.docs[j], s.docs[i]
+	s.entries[i], s.entries[j] = s.entries[j], s.entries[i]
+}
+
+func sortKeyValues[T any](doc FlatDBModel[T], keys []orderKey) []interface{} {
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		field := key.path.first(doc)
+		if !field.IsValid() || !field.CanInterface() {
+			continue
+		}
+
+		values[i] = normalizeValue(field.Interface())
+	}
+
+	return values
+}
+
+func compareSortEntries(a sortEntry, b sortEntry, keys []orderKey) int {
+	for i, key := range keys {
+		res := compareIndexKeys(a.values[i], b.values[i])
+		if key.direction == Desc {
+			res = -res
+		}
+
+		if res != 0 {
+			return res
+		}
+	}
+
+	return compareOrdered(a.id, b.id)
+}
+
+func errorOrderingDocuments(err error) error {
+	return fmt.Errorf("error ordering documents: %w", err)
+}
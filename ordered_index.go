@@ -0,0 +1,188 @@
+package goflatdb
+
+import (
+	"math/rand"
+)
+
+const (
+	skipListMaxLevel    = 32
+	skipListProbability = 0.25
+)
+
+type flatDBOrderedIndex struct {
+	fieldName string
+	path      fieldPath
+
+	data *skipList // key - fieldName, val - fileNames, ordered by compareIndexKeys
+}
+
+// indexBound is an end of a range scan over an ordered index.
+type indexBound struct {
+	key       interface{}
+	inclusive bool
+}
+
+func newFlatDBOrderedIndex(fieldName string) *flatDBOrderedIndex {
+	return &flatDBOrderedIndex{
+		fieldName: fieldName,
+		data:      newSkipList(),
+	}
+}
+
+func (idx *flatDBOrderedIndex) add(key interface{}, fileName string) {
+	idx.data.add(key, fileName)
+}
+
+func (idx *flatDBOrderedIndex) remove(key interface{}, fileName string) {
+	idx.data.remove(key, fileName)
+}
+
+func (idx *flatDBOrderedIndex) get(key interface{}) ([]string, bool) {
+	node := idx.data.get(key)
+	if node == nil {
+		return nil, false
+	}
+
+	return node.fileNames, true
+}
+
+// scan calls fn for every key between lower and upper in ascending order until fn returns false.
+// A nil bound means the range is unbounded on that side.
+func (idx *flatDBOrderedIndex) scan(lower *indexBound, upper *indexBound, fn func(key interface{}, fileNames []string) bool) {
+	var node *skipListNode
+	if lower == nil {
+		node = idx.data.head.next[0]
+	} else {
+		node = idx.data.seek(lower.key, lower.inclusive)
+	}
+
+	for ; node != nil; node = node.next[0] {
+		if upper != nil {
+			res := compareIndexKeys(node.key, upper.key)
+			if res > 0 || (res == 0 && !upper.inclusive) {
+				return
+			}
+		}
+
+		if !fn(node.key, node.fileNames) {
+			return
+		}
+	}
+}
+
+type skipListNode struct {
+	key       interface{}
+	fileNames []string
+
+	next []*skipListNode
+}
+
+type skipList struct {
+	head  *skipListNode
+	level int
+
+	rnd *rand.Rand
+}
+
+func newSkipList() *skipList {
+	return &skipList{
+		head:  &skipListNode{next: make([]*skipListNode, skipListMaxLevel)},
+		level: 1,
+		rnd:   rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+func (l *skipList) randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && l.rnd.Float64() < skipListProbability {
+		level++
+	}
+
+	return level
+}
+
+// seek returns the first node whose key is greater than (or equal to, if inclusive) key.
+// If update is not nil it is filled with the rightmost node before the result on every level.
+func (l *skipList) seek(key interface{}, inclusive bool, update ...[]*skipListNode) *skipListNode {
+	node := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for next := node.next[i]; next != nil; next = node.next[i] {
+			res := compareIndexKeys(next.key, key)
+			if res > 0 || (res == 0 && inclusive) {
+				break
+			}
+			node = next
+		}
+
+		if len(update) > 0 {
+			update[0][i] = node
+		}
+	}
+
+	return node.next[0]
+}
+
+func (l *skipList) get(key interface{}) *skipListNode {
+	node := l.seek(key, true)
+	if node == nil || compareIndexKeys(node.key, key) != 0 {
+		return nil
+	}
+
+	return node
+}
+
+func (l *skipList) add(key interface{}, fileName string) {
+	update := make([]*skipListNode, skipListMaxLevel)
+	node := l.seek(key, true, update)
+	if node != nil && compareIndexKeys(node.key, key) == 0 {
+		node.fileNames = append(node.fileNames, fileName)
+		return
+	}
+
+	level := l.randomLevel()
+	if level > l.level {
+		for i := l.level; i < level; i++ {
+			update[i] = l.head
+		}
+		l.level = level
+	}
+
+	node = &skipListNode{
+		key:       key,
+		fileNames: []string{fileName},
+		next:      make([]*skipListNode, level),
+	}
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+}
+
+func (l *skipList) remove(key interface{}, fileName string) {
+	update := make([]*skipListNode, skipListMaxLevel)
+	node := l.seek(key, true, update)
+	if node == nil || compareIndexKeys(node.key, key) != 0 {
+		return
+	}
+
+	for i, name := range node.fileNames {
+		if name != fileName {
+			continue
+		}
+
+		node.fileNames = append(node.fileNames[:i], node.fileNames[i+1:]...)
+		break
+	}
+
+	if len(node.fileNames) > 0 {
+		return
+	}
+
+	for i := 0; i < len(node.next); i++ {
+		update[i].next[i] = node.next[i]
+	}
+
+	for l.level > 1 && l.head.next[l.level-1] == nil {
+		l.level--
+	}
+}
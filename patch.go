@@ -0,0 +1,74 @@
+package goflatdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// applyMergePatch applies a JSON Merge Patch (RFC 7396) to the target document.
+func applyMergePatch(target []byte, patch []byte) ([]byte, error) {
+	targetVal, err := decodeMergePatchValue(target)
+	if err != nil {
+		return nil, errorApplyingMergePatch(err)
+	}
+
+	patchVal, err := decodeMergePatchValue(patch)
+	if err != nil {
+		return nil, errorApplyingMergePatch(err)
+	}
+
+	res, err := json.Marshal(mergePatch(targetVal, patchVal))
+	if err != nil {
+		return nil, errorApplyingMergePatch(err)
+	}
+
+	return res, nil
+}
+
+// decodeMergePatchValue decodes a JSON value keeping numbers as json.Number, so integers too large
+// for a float64 survive the patch unchanged.
+func decodeMergePatchValue(data []byte) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	if _, err := d.Token(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("invalid character after top-level value")
+	}
+
+	return v, nil
+}
+
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = map[string]interface{}{}
+	}
+
+	for k, v := range patchObj {
+		if v == nil {
+			delete(targetObj, k)
+			continue
+		}
+
+		targetObj[k] = mergePatch(targetObj[k], v)
+	}
+
+	return targetObj
+}
+
+func errorApplyingMergePatch(err error) error {
+	return fmt.Errorf("error applying merge patch: %w", err)
+}
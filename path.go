@@ -0,0 +1,327 @@
+package goflatdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// fieldPath addresses values nested in a document. Paths are written as:
+//   - "Name" is a top level field of a struct, or a key of a map with string keys.
+//   - "Address.City" is the field City of the field Address, dots descend through structs and maps alike.
+//   - `Attrs["a.b"]` is a quoted map key or field name, for names containing dots or brackets.
+//   - "Tags[2]" is the element at index 2 of a slice or an array.
+//   - "Tags[*]" is every element of a slice or an array, so a path may resolve to several values.
+type fieldPath []pathSegment
+
+type pathSegmentKind uint8
+
+const (
+	pathSegmentField pathSegmentKind = iota
+	pathSegmentIndex
+	pathSegmentWildcard
+)
+
+type pathSegment struct {
+	kind  pathSegmentKind
+	name  string // field name or map key of pathSegmentField
+	index int    // element index of pathSegmentIndex
+}
+
+func parsePath(path string) (fieldPath, error) {
+	segments := fieldPath{}
+
+	rest := path
+	for len(rest) > 0 {
+		if rest[0] == '[' {
+			segment, n, err := parseBracketSegment(rest)
+			if err != nil {
+				return nil, errorParsingPath(path, err)
+			}
+
+			segments = append(segments, segment)
+			rest = rest[n:]
+			continue
+		}
+
+		if len(segments) > 0 {
+			if rest[0] != '.' {
+				return nil, errorParsingPath(path, fmt.Errorf("unexpected %q", rest[0]))
+			}
+			rest = rest[1:]
+		}
+
+		end := strings.IndexAny(rest, ".[")
+		if end < 0 {
+			end = len(rest)
+		}
+		if end == 0 {
+			return nil, errorParsingPath(path, errors.New("empty field name"))
+		}
+
+		segments = append(segments, pathSegment{kind: pathSegmentField, name: rest[:end]})
+		rest = rest[end:]
+	}
+
+	if len(segments) == 0 {
+		return nil, errorParsingPath(path, errors.New("empty path"))
+	}
+
+	return segments, nil
+}
+
+// parseBracketSegment parses the segment s starts with, returning it and its length.
+func parseBracketSegment(s string) (pathSegment, int, error) {
+	inner := s[1:]
+
+	if strings.HasPrefix(inner, `"`) {
+		quoted, err := strconv.QuotedPrefix(inner)
+		if err != nil {
+			return pathSegment{}, 0, fmt.Errorf("invalid quoted name: %w", err)
+		}
+		if !strings.HasPrefix(inner[len(quoted):], "]") {
+			return pathSegment{}, 0, errors.New("missing ]")
+		}
+
+		name, err := strconv.Unquote(quoted)
+		if err != nil {
+			return pathSegment{}, 0, fmt.Errorf("invalid quoted name: %w", err)
+		}
+
+		return pathSegment{kind: pathSegmentField, name: name}, len(quoted) + 2, nil
+	}
+
+	end := strings.IndexByte(inner, ']')
+	if end < 0 {
+		return pathSegment{}, 0, errors.New("missing ]")
+	}
+
+	if inner[:end] == "*" {
+		return pathSegment{kind: pathSegmentWildcard}, end + 2, nil
+	}
+
+	index, err := strconv.Atoi(inner[:end])
+	if err != nil || index < 0 {
+		return pathSegment{}, 0, fmt.Errorf("invalid index %q", inner[:end])
+	}
+
+	return pathSegment{kind: pathSegmentIndex, index: index}, end + 2, nil
+}
+
+// String formats p so that parsePath returns p again.
+func (p fieldPath) String() string {
+	var b strings.Builder
+	for i, segment := range p {
+		switch segment.kind {
+		case pathSegmentField:
+			if segment.name == "" || strings.ContainsAny(segment.name, `.[]"`) {
+				b.WriteString("[" + strconv.Quote(segment.name) + "]")
+				continue
+			}
+
+			if i > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(segment.name)
+		case pathSegmentIndex:
+			b.WriteString("[" + strconv.Itoa(segment.index) + "]")
+		case pathSegmentWildcard:
+			b.WriteString("[*]")
+		}
+	}
+
+	return b.String()
+}
+
+// lookup returns the values p resolves to in data, which is empty if data has no such values.
+// Pointers and interfaces are followed and json.RawMessage values are decoded on the way.
+// In a FlatDBModel, paths starting with a metadata field name resolve to the metadata, other paths to the data.
+func (p fieldPath) lookup(data interface{}) []reflect.Value {
+	root := reflect.ValueOf(data)
+	if doc, ok := data.(documentFields); ok {
+		root = doc.dataValue()
+		if len(p) > 0 && p[0].kind == pathSegmentField {
+			if meta, ok := doc.metaValue(p[0].name); ok {
+				root, p = meta, p[1:]
+			}
+		}
+	}
+
+	values := []reflect.Value{root}
+	for _, segment := range p {
+		next := make([]reflect.Value, 0, len(values))
+		for _, v := range values {
+			next = segment.appendValues(next, indirect(v))
+		}
+
+		values = next
+	}
+
+	res := values[:0]
+	for _, v := range values {
+		if v = indirect(v); v.IsValid() {
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
+
+// first returns the first value p resolves to in data, or an invalid reflect.Value if there is none.
+func (p fieldPath) first(data interface{}) reflect.Value {
+	values := p.lookup(data)
+	if len(values) == 0 {
+		return reflect.Value{}
+	}
+
+	return values[0]
+}
+
+func (s pathSegment) appendValues(values []reflect.Value, v reflect.Value) []reflect.Value {
+	switch s.kind {
+	case pathSegmentField:
+		switch v.Kind() {
+		case reflect.Struct:
+			field, ok := resolveStructField(v.Type(), s.name)
+			if !ok {
+				return values
+			}
+
+			// promoted fields of nil embedded pointers are missing
+			if fieldVal, err := v.FieldByIndexErr(field.Index); err == nil {
+				return append(values, fieldVal)
+			}
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return values
+			}
+
+			if elem := v.MapIndex(reflect.ValueOf(s.name).Convert(v.Type().Key())); elem.IsValid() {
+				return append(values, elem)
+			}
+		}
+	case pathSegmentIndex:
+		if (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && s.index < v.Len() {
+			return append(values, v.Index(s.index))
+		}
+	case pathSegmentWildcard:
+		if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+			for i := 0; i < v.Len(); i++ {
+				values = append(values, v.Index(i))
+			}
+		}
+	}
+
+	return values
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+
+// indirect returns the value v points to, holds or encodes. Nil pointers and interfaces are kept, so they read as null,
+// and json.RawMessage values that fail to decode are returned as an invalid reflect.Value.
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() {
+		switch {
+		case (v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer) && !v.IsNil():
+			v = v.Elem()
+		case v.Type() == rawMessageType:
+			var decoded interface{}
+			if err := json.Unmarshal(v.Bytes(), &decoded); err != nil {
+				return reflect.Value{}
+			}
+			v = reflect.ValueOf(&decoded).Elem()
+		default:
+			return v
+		}
+	}
+
+	return v
+}
+
+// expandLists returns values followed by the elements of the slices and arrays among them, so a slice field
+// is matched and indexed by its elements as well as by itself. Byte slices are kept whole.
+func expandLists(values []reflect.Value) []reflect.Value {
+	res := values
+	for _, v := range values {
+		v = indirect(v)
+		if !v.IsValid() || (v.Kind() != reflect.Slice && v.Kind() != reflect.Array) || v.Type().Elem().Kind() == reflect.Uint8 {
+			continue
+		}
+
+		if len(res) == len(values) {
+			res = append([]reflect.Value(nil), values...)
+		}
+		for i := 0; i < v.Len(); i++ {
+			res = append(res, indirect(v.Index(i)))
+		}
+	}
+
+	return res
+}
+
+// indexKeys returns the distinct normalized values path resolves to in data. Slices are indexed by their
+// elements, values that can't be map keys, such as the slices themselves, are left out.
+func indexKeys(data interface{}, path fieldPath) []interface{} {
+	keys := []interface{}{}
+	for _, v := range expandLists(path.lookup(data)) {
+		if !v.IsValid() || !v.CanInterface() {
+			continue
+		}
+
+		key := normalizeValue(v.Interface())
+		if !isHashable(reflect.ValueOf(key)) {
+			continue
+		}
+
+		if !containsIndexKey(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// isHashable reports whether v can be used as a map key without panicking.
+func isHashable(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Func:
+		return false
+	case reflect.Interface:
+		return v.IsNil() || isHashable(v.Elem())
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !isHashable(v.Index(i)) {
+				return false
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !isHashable(v.Field(i)) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func containsIndexKey(keys []interface{}, key interface{}) bool {
+	for _, k := range keys {
+		if compareIndexKeys(k, key) == 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func errorParsingPath(path string, err error) error {
+	return fmt.Errorf("error parsing path %q: %w", path, err)
+}
@@ -0,0 +1,363 @@
+package goflatdb
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestParsePath(t *testing.T) {
+	testCases := []struct {
+		path     string
+		expected fieldPath
+	}{
+		{path: "Foo", expected: fieldPath{{kind: pathSegmentField, name: "Foo"}}},
+		{path: "Address.City", expected: fieldPath{
+			{kind: pathSegmentField, name: "Address"},
+			{kind: pathSegmentField, name: "City"},
+		}},
+		{path: "Tags[*]", expected: fieldPath{
+			{kind: pathSegmentField, name: "Tags"},
+			{kind: pathSegmentWildcard},
+		}},
+		{path: "Items[1].Price", expected: fieldPath{
+			{kind: pathSegmentField, name: "Items"},
+			{kind: pathSegmentIndex, index: 1},
+			{kind: pathSegmentField, name: "Price"},
+		}},
+		{path: `Attrs["a.b[c]"].d`, expected: fieldPath{
+			{kind: pathSegmentField, name: "Attrs"},
+			{kind: pathSegmentField, name: "a.b[c]"},
+			{kind: pathSegmentField, name: "d"},
+		}},
+	}
+
+	for _, tc := range testCases {
+		path, err := parsePath(tc.path)
+		require.NoError(t, err, tc.path)
+		require.Equal(t, tc.expected, path, tc.path)
+	}
+
+	for _, path := range []string{"", ".Foo", "Foo.", "Foo..Bar", "Foo[", "Foo[-1]", "Foo[x]", `Foo["x`, "Foo[*]Bar"} {
+		_, err := parsePath(path)
+		require.Error(t, err, path)
+	}
+}
+
+type pathTestItem struct {
+	Name  string
+	Price int
+}
+
+type pathTestAddress struct {
+	City string
+}
+
+type pathTestData struct {
+	Address pathTestAddress
+	Tags    []string
+	Items   []pathTestItem
+	Attrs   map[string]interface{}
+}
+
+func TestNestedFieldQuery(t *testing.T) {
+	for _, withIndex := range []bool{false, true} {
+		withIndex := withIndex
+		t.Run(map[bool]string{false: "scan", true: "index"}[withIndex], func(t *testing.T) {
+			dir := t.TempDir()
+
+			logger, err := zap.NewDevelopment()
+			require.NoError(t, err)
+
+			db, err := NewFlatDB(dir, logger)
+			require.NoError(t, err)
+
+			opts := []FlatDBCollectionOption[pathTestData]{}
+			if withIndex {
+				opts = append(opts,
+					WithUnorderedIndex[pathTestData]("Address.City"),
+					WithUnorderedIndex[pathTestData]("Tags[*]"),
+					WithOrderedIndex[pathTestData]("Items[*].Price"),
+					WithCompositeIndex[pathTestData]("Address.City", "Tags[*]"),
+				)
+			}
+
+			col, err := NewFlatDBCollection[pathTestData](db, "test-collection", logger, opts...)
+			require.NoError(t, err)
+
+			docs := []pathTestData{
+				{
+					Address: pathTestAddress{City: "paris"},
+					Tags:    []string{"a", "b"},
+					Items:   []pathTestItem{{Name: "x", Price: 10}, {Name: "y", Price: 30}},
+					Attrs:   map[string]interface{}{"color": "red", "size": map[string]interface{}{"w": 1}},
+				},
+				{
+					Address: pathTestAddress{City: "berlin"},
+					Tags:    []string{"b", "c"},
+					Items:   []pathTestItem{{Name: "z", Price: 20}},
+					Attrs:   map[string]interface{}{"color": "blue"},
+				},
+				{
+					Address: pathTestAddress{City: "paris"},
+					Tags:    []string{"c", "c"},
+				},
+			}
+			for i := range docs {
+				_, err := col.Insert(&docs[i])
+				require.NoError(t, err)
+			}
+
+			queryIDs := func(t *testing.T, q *QueryBuilder[pathTestData]) []uint64 {
+				docs, err := q.Execute()
+				require.NoError(t, err)
+
+				ids := []uint64{}
+				for _, doc := range docs {
+					ids = append(ids, doc.ID)
+				}
+				return ids
+			}
+			where := func(fieldName string, op string, value interface{}) *QueryBuilder[pathTestData] {
+				return col.QueryBuilder().Where(fieldName, op, value)
+			}
+
+			require.Equal(t, []uint64{1, 3}, queryIDs(t, where("Address.City", "=", "paris")))
+			require.Equal(t, []uint64{1}, queryIDs(t, where("Tags[*]", "=", "a")))
+			require.Equal(t, []uint64{1, 2}, queryIDs(t, where("Tags[*]", "=", "b")))
+			require.Equal(t, []uint64{2, 3}, queryIDs(t, where("Tags[*]", "in", []string{"c"})))
+			require.Equal(t, []uint64{2}, queryIDs(t, where("Tags[0]", "=", "b")))
+			require.Equal(t, []uint64{2, 3}, queryIDs(t, where("Tags[*]", "!=", "a")))
+			require.Equal(t, []uint64{1, 2}, queryIDs(t, where("Items[*].Price", ">=", 20)))
+			require.Equal(t, []uint64{1}, queryIDs(t, where("Items[*].Price", "between", []int{25, 35})))
+			require.Equal(t, []uint64{1}, queryIDs(t, where("Attrs.color", "=", "red")))
+			require.Equal(t, []uint64{1}, queryIDs(t, where("Attrs.size.w", "=", 1)))
+			require.Equal(t, []uint64{3}, queryIDs(t, where("Attrs.color", "exists", false)))
+			require.Equal(t, []uint64{3}, queryIDs(t, where("Items[0]", "is null", true)))
+			require.Equal(t, []uint64{3}, queryIDs(t, where("Address.City", "=", "paris").
+				And(where("Tags[*]", "=", "c"))))
+
+			require.Equal(t, []uint64{2, 1, 3}, queryIDs(t, col.QueryBuilder().Select().OrderBy("Address.City", Asc)))
+
+			err = col.Update(1, &pathTestData{Address: pathTestAddress{City: "rome"}, Tags: []string{"d"}})
+			require.NoError(t, err)
+
+			require.Equal(t, []uint64{3}, queryIDs(t, where("Address.City", "=", "paris")))
+			require.Equal(t, []uint64{}, queryIDs(t, where("Tags[*]", "=", "a")))
+			require.Equal(t, []uint64{2}, queryIDs(t, where("Tags[*]", "=", "b")))
+			require.Equal(t, []uint64{1}, queryIDs(t, where("Tags[*]", "=", "d")))
+			require.Equal(t, []uint64{2}, queryIDs(t, where("Items[*].Price", ">=", 20)))
+
+			_, err = where("Tags[", "=", "a").Execute()
+			require.ErrorIs(t, err, InvalidQuery)
+		})
+	}
+
+	t.Run("index on a slice field", func(t *testing.T) {
+		dir := t.TempDir()
+
+		logger, err := zap.NewDevelopment()
+		require.NoError(t, err)
+
+		db, err := NewFlatDB(dir, logger)
+		require.NoError(t, err)
+
+		col, err := NewFlatDBCollection[pathTestData](db, "test-collection", logger, WithUnorderedIndex[pathTestData]("Tags"))
+		require.NoError(t, err)
+
+		_, err = col.Insert(&pathTestData{Tags: []string{"a", "b"}})
+		require.NoError(t, err)
+		_, err = col.Insert(&pathTestData{Tags: []string{"b", "c"}})
+		require.NoError(t, err)
+
+		for _, q := range []*QueryBuilder[pathTestData]{
+			col.QueryBuilder().Where("Tags", "=", "a"),
+			col.QueryBuilder().Where("Tags", "=", "a").Or(col.QueryBuilder().Where("Tags", "=", "x")),
+		} {
+			found, err := q.Execute()
+			require.NoError(t, err)
+			require.Len(t, found, 1)
+			require.Equal(t, uint64(1), found[0].ID)
+		}
+
+		found, err := col.QueryBuilder().Where("Tags", "in", []string{"c", "x"}).Execute()
+		require.NoError(t, err)
+		require.Len(t, found, 1)
+		require.Equal(t, uint64(2), found[0].ID)
+
+		found, err = col.QueryBuilder().Where("Tags", "contains", "b").Execute()
+		require.NoError(t, err)
+		require.Len(t, found, 2)
+	})
+
+	t.Run("invalid index path", func(t *testing.T) {
+		dir := t.TempDir()
+
+		logger, err := zap.NewDevelopment()
+		require.NoError(t, err)
+
+		db, err := NewFlatDB(dir, logger)
+		require.NoError(t, err)
+
+		_, err = NewFlatDBCollection[pathTestData](db, "test-collection", logger, WithUnorderedIndex[pathTestData]("Tags[x]"))
+		require.Error(t, err)
+	})
+}
+
+func TestDynamicDocumentTypes(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	db, err := NewFlatDB(dir, logger)
+	require.NoError(t, err)
+
+	t.Run("pointer", func(t *testing.T) {
+		type manager struct {
+			Name string
+		}
+		type employee struct {
+			Name    string `json:"name"`
+			Age     *int
+			Manager *manager
+		}
+
+		col, err := NewFlatDBCollection[*employee](db, "pointer", logger,
+			WithUnorderedIndex[*employee]("name"),
+			WithOrderedIndex[*employee]("Manager.Name"),
+			WithOrderedIndex[*employee]("Age"))
+		require.NoError(t, err)
+
+		age := 30
+		for _, doc := range []*employee{
+			{Name: "a", Age: &age, Manager: &manager{Name: "m"}},
+			{Name: "b"},
+			nil,
+		} {
+			doc := doc
+			_, err := col.Insert(&doc)
+			require.NoError(t, err)
+		}
+
+		docs, err := col.QueryBuilder().Where("name", "=", "a").Execute()
+		require.NoError(t, err)
+		require.Len(t, docs, 1)
+		require.Equal(t, "m", docs[0].Data.Manager.Name)
+
+		docs, err = col.QueryBuilder().Where("Age", "=", 30).Execute()
+		require.NoError(t, err)
+		require.Len(t, docs, 1)
+
+		// nil pointers don't match range operators
+		for _, q := range []*QueryBuilder[*employee]{
+			col.QueryBuilder().Where("Age", ">", 1),
+			col.QueryBuilder().Where("Age", "between", []int{1, 40}),
+			col.QueryBuilder().Where("Age", ">", 1).Or(col.QueryBuilder().Where("name", "=", "x")),
+		} {
+			docs, err = q.Execute()
+			require.NoError(t, err)
+			require.Len(t, docs, 1)
+			require.Equal(t, uint64(1), docs[0].ID)
+		}
+
+		docs, err = col.QueryBuilder().Where("Manager.Name", ">=", "a").Execute()
+		require.NoError(t, err)
+		require.Len(t, docs, 1)
+		require.Equal(t, uint64(1), docs[0].ID)
+
+		docs, err = col.QueryBuilder().Where("Manager", "is null", true).Execute()
+		require.NoError(t, err)
+		require.Len(t, docs, 2)
+
+		_, err = col.Patch(2, []byte(`{"Manager": {"Name": "n"}}`))
+		require.NoError(t, err)
+
+		docs, err = col.QueryBuilder().Where("Manager.Name", "=", "n").Execute()
+		require.NoError(t, err)
+		require.Len(t, docs, 1)
+		require.Equal(t, uint64(2), docs[0].ID)
+	})
+
+	t.Run("map", func(t *testing.T) {
+		col, err := NewFlatDBCollection[map[string]interface{}](db, "map", logger,
+			WithUnorderedIndex[map[string]interface{}]("tags[*]"),
+			WithOrderedIndex[map[string]interface{}]("profile.age"))
+		require.NoError(t, err)
+
+		for _, doc := range []map[string]interface{}{
+			{"tags": []interface{}{"x", "y"}, "profile": map[string]interface{}{"age": 20}, "rank": 1},
+			{"tags": []interface{}{"y"}, "profile": map[string]interface{}{"age": 40}},
+			{"profile": nil},
+			{"profile": map[string]interface{}{"age": "old"}, "rank": "first"},
+		} {
+			doc := doc
+			_, err := col.Insert(&doc)
+			require.NoError(t, err)
+		}
+
+		docs, err := col.QueryBuilder().Where("tags[*]", "=", "y").Execute()
+		require.NoError(t, err)
+		require.Len(t, docs, 2)
+
+		docs, err = col.QueryBuilder().Where("profile.age", ">", 30).Execute()
+		require.NoError(t, err)
+		require.Len(t, docs, 1)
+		require.Equal(t, uint64(2), docs[0].ID)
+
+		// values of another kind than the operand don't match, on the index and in a scan
+		docs, err = col.QueryBuilder().Where("rank", ">=", 1).Execute()
+		require.NoError(t, err)
+		require.Len(t, docs, 1)
+		require.Equal(t, uint64(1), docs[0].ID)
+
+		docs, err = col.QueryBuilder().Where("profile", "is null", true).Execute()
+		require.NoError(t, err)
+		require.Len(t, docs, 1)
+		require.Equal(t, uint64(3), docs[0].ID)
+	})
+
+	t.Run("raw message", func(t *testing.T) {
+		col, err := NewFlatDBCollection[json.RawMessage](db, "raw", logger,
+			WithUnorderedIndex[json.RawMessage]("kind"),
+			WithCompositeIndex[json.RawMessage]("kind", "spec.replicas"))
+		require.NoError(t, err)
+
+		for _, doc := range []json.RawMessage{
+			json.RawMessage(`{"kind": "pod", "spec": {"replicas": 1}}`),
+			json.RawMessage(`{"kind": "deployment", "spec": {"replicas": 3}}`),
+			json.RawMessage(`{"kind": "deployment", "spec": {"replicas": 5}}`),
+			json.RawMessage(`[1, 2]`),
+		} {
+			doc := doc
+			_, err := col.Insert(&doc)
+			require.NoError(t, err)
+		}
+
+		docs, err := col.QueryBuilder().Where("kind", "=", "deployment").Execute()
+		require.NoError(t, err)
+		require.Len(t, docs, 2)
+
+		docs, err = col.QueryBuilder().
+			Where("kind", "=", "deployment").
+			And(col.QueryBuilder().Where("spec.replicas", "=", 5)).
+			Execute()
+		require.NoError(t, err)
+		require.Len(t, docs, 1)
+		require.JSONEq(t, `{"kind": "deployment", "spec": {"replicas": 5}}`, string(docs[0].Data))
+
+		docs, err = col.QueryBuilder().Where("[1]", "=", 2).Execute()
+		require.NoError(t, err)
+		require.Len(t, docs, 1)
+		require.Equal(t, uint64(4), docs[0].ID)
+
+		err = col.Update(1, &[]json.RawMessage{json.RawMessage(`{"kind": "deployment"}`)}[0])
+		require.NoError(t, err)
+
+		docs, err = col.QueryBuilder().Where("kind", "=", "deployment").Execute()
+		require.NoError(t, err)
+		require.Len(t, docs, 3)
+	})
+}
@@ -1,23 +1,63 @@
 package goflatdb
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
 type QueryOperator uint8
 
+// Operators compare a document field with the value passed to Where:
+//   - "=", "!=" test equality; ordered kinds are compared like "<", the rest with reflect.DeepEqual.
+//   - "<", "<=", ">", ">=" order ints, uints, floats, strings (byte-wise), bools (false < true) and time.Time.
+//     The operand must be of one of these kinds, fields of another kind than the operand, or nil, don't match.
+//   - "in", "not in" take a slice or an array and test whether the field equals any of its elements.
+//   - "between" takes a slice or an array of two bounds that can be compared with each other and tests lower <= field <= upper.
+//   - "prefix" takes a string and matches string fields starting with it.
+//   - "contains" matches string fields containing the given substring and slice fields containing the given element.
+//   - "regex" takes a pattern string or a *regexp.Regexp and matches string fields.
+//   - "exists" matches documents that have the field, "is null" matches documents where the field is missing or nil.
+//     Both accept nil or true, while false negates them.
+//
+// Except for "exists" and "is null" a document without the field never matches.
 const (
 	OperatorEquals = iota
 	OperatorLess
 	OperatorMore
+	OperatorNotEquals
+	OperatorLessOrEquals
+	OperatorMoreOrEquals
+	OperatorIn
+	OperatorNotIn
+	OperatorBetween
+	OperatorPrefix
+	OperatorContains
+	OperatorRegex
+	OperatorExists
+	OperatorIsNull
 )
 
 var operators = map[string]QueryOperator{
-	"=": OperatorEquals,
-	"<": OperatorLess,
-	">": OperatorMore,
+	"=":        OperatorEquals,
+	"<":        OperatorLess,
+	">":        OperatorMore,
+	"!=":       OperatorNotEquals,
+	"<=":       OperatorLessOrEquals,
+	">=":       OperatorMoreOrEquals,
+	"in":       OperatorIn,
+	"not in":   OperatorNotIn,
+	"between":  OperatorBetween,
+	"prefix":   OperatorPrefix,
+	"contains": OperatorContains,
+	"regex":    OperatorRegex,
+	"exists":   OperatorExists,
+	"is null":  OperatorIsNull,
 }
 
 type Query[T any] interface {
 	Execute() ([]FlatDBModel[T], error)
+	// Iter returns a cursor that reads the results lazily. Unless the query is ordered with OrderBy, results are ordered by ID.
+	Iter(ctx context.Context) (Cursor[T], error)
 }
 
 type QueryBuilder[T any] struct {
@@ -25,6 +65,9 @@ type QueryBuilder[T any] struct {
 	Q   Query[T]
 }
 
+// Where filters documents by the value of fieldName. fieldName may be a path into nested values:
+// "Address.City" descends into structs and maps, "Tags[0]" is an element of a slice and "Tags[*]" is each of them.
+// A path resolving to several values matches if any of them does, or for "!=" and "not in" if all of them do.
 func (c *QueryBuilder[T]) Where(fieldName string, operator string, fieldValue interface{}) *QueryBuilder[T] {
 	whereQuery := &WhereQuery[T]{
 		col:        c.col,
@@ -41,6 +84,16 @@ func (c *QueryBuilder[T]) Where(fieldName string, operator string, fieldValue in
 
 	whereQuery.operator = op
 
+	if whereQuery.err == nil {
+		if whereQuery.path, err = parsePath(fieldName); err != nil {
+			whereQuery.err = fmt.Errorf("%w: %w", InvalidQuery, err)
+		}
+	}
+
+	if whereQuery.err == nil {
+		whereQuery.fieldValue, whereQuery.err = prepareOperand(op, fieldValue)
+	}
+
 	c.Q = whereQuery
 
 	return c
@@ -106,10 +159,73 @@ func (c *QueryBuilder[T]) Offset(n int) *QueryBuilder[T] {
 	return c
 }
 
+// OrderBy sorts the results by fieldName. Calling it repeatedly adds tie-breaking keys,
+// documents equal on all keys are ordered by ID.
+func (c *QueryBuilder[T]) OrderBy(fieldName string, direction SortDirection) *QueryBuilder[T] {
+	path, err := parsePath(fieldName)
+	if err != nil {
+		err = fmt.Errorf("%w: %w", InvalidQuery, err)
+	}
+
+	key := orderKey{
+		fieldName: fieldName,
+		path:      path,
+		direction: direction,
+	}
+
+	if orderByQuery, ok := c.Q.(*OrderByQuery[T]); ok {
+		orderByQuery.keys = append(orderByQuery.keys, key)
+		if orderByQuery.err == nil {
+			orderByQuery.err = err
+		}
+
+		return c
+	}
+
+	orderByQuery := OrderByQuery[T]{
+		col:  c.col,
+		q:    c.Q,
+		keys: []orderKey{key},
+		err:  err,
+	}
+
+	c.Q = &orderByQuery
+
+	return c
+}
+
 func (c *QueryBuilder[T]) Execute() ([]FlatDBModel[T], error) {
 	return c.Q.Execute()
 }
 
+// ExecuteContext is like Execute, but stops reading documents once ctx is done.
+func (c *QueryBuilder[T]) ExecuteContext(ctx context.Context) ([]FlatDBModel[T], error) {
+	return executeQueryContext(ctx, c.Q)
+}
+
+func (c *QueryBuilder[T]) Iter(ctx context.Context) (Cursor[T], error) {
+	return c.Q.Iter(ctx)
+}
+
+// executeQuery reads all results of q.
+func executeQuery[T any](q Query[T]) ([]FlatDBModel[T], error) {
+	return executeQueryContext(context.Background(), q)
+}
+
+func executeQueryContext[T any](ctx context.Context, q Query[T]) ([]FlatDBModel[T], error) {
+	cur, err := q.Iter(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	docs, err := collect(cur)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
+	}
+
+	return docs, nil
+}
+
 type NopQuery[T any] struct {
 }
 
@@ -117,10 +233,15 @@ func (c *NopQuery[T]) Execute() ([]FlatDBModel[T], error) {
 	return []FlatDBModel[T]{}, nil
 }
 
+func (c *NopQuery[T]) Iter(ctx context.Context) (Cursor[T], error) {
+	return &documentCursor[T]{ctx: ctx}, nil
+}
+
 type WhereQuery[T any] struct {
 	col *FlatDBCollection[T]
 
 	fieldName  string
+	path       fieldPath
 	fieldValue interface{}
 	operator   QueryOperator
 
@@ -128,16 +249,20 @@ type WhereQuery[T any] struct {
 }
 
 func (c *WhereQuery[T]) Execute() ([]FlatDBModel[T], error) {
+	return executeQuery[T](c)
+}
+
+func (c *WhereQuery[T]) Iter(ctx context.Context) (Cursor[T], error) {
 	if c.err != nil {
 		return nil, fmt.Errorf("error executing where query: %w", c.err)
 	}
 
-	docs, err := c.col.findBy(c.fieldName, c.fieldValue)
+	cur, err := c.col.whereCursor(ctx, c.fieldName, c.operator, c.fieldValue)
 	if err != nil {
 		return nil, fmt.Errorf("error executing where query: %w", err)
 	}
 
-	return docs, nil
+	return cur, nil
 }
 
 type AndQuery[T any] struct {
@@ -150,30 +275,140 @@ type AndQuery[T any] struct {
 }
 
 func (c *AndQuery[T]) Execute() ([]FlatDBModel[T], error) {
-	leftResult, err := c.left.Execute()
+	return executeQuery[T](c)
+}
+
+// Iter looks the documents up in a composite index if the equality conditions of the query cover
+// a prefix of one. Otherwise it reads the documents of one side and filters them with the other side.
+// If neither side can test a single document, the ids returned by the right side are collected first.
+func (c *AndQuery[T]) Iter(ctx context.Context) (Cursor[T], error) {
+	if cur, ok, err := c.compositeIndexIter(ctx); ok {
+		if err != nil {
+			return nil, fmt.Errorf("error executing and query: %w", err)
+		}
+
+		return cur, nil
+	}
+
+	driver, filter := c.sides()
+
+	cur, err := driver.Iter(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("error executing and query: %w", err)
 	}
 
-	rightResult, err := c.right.Execute()
+	if match, ok := queryMatcher(filter); ok {
+		return &filterCursor[T]{cur: cur, match: match}, nil
+	}
+
+	ids, err := collectIDs(ctx, filter)
 	if err != nil {
+		_ = cur.Close()
 		return nil, fmt.Errorf("error executing and query: %w", err)
 	}
 
-	leftSet := map[uint64]struct{}{}
-	for _, doc := range leftResult {
-		leftSet[doc.ID] = struct{}{}
+	return &filterCursor[T]{
+		cur: cur,
+		match: func(doc FlatDBModel[T]) (bool, error) {
+			_, ok := ids[doc.ID]
+			return ok, nil
+		},
+	}, nil
+}
+
+// compositeIndexIter reads the documents found by lookupCompositeIndex with the equality conditions of c,
+// filtering them with every condition of c. ok is false if no composite index can be used.
+func (c *AndQuery[T]) compositeIndexIter(ctx context.Context) (cur Cursor[T], ok bool, err error) {
+	conjuncts := c.conjuncts()
+
+	equalities := map[string]interface{}{}
+	for _, q := range conjuncts {
+		where, ok := q.(*WhereQuery[T])
+		if !ok || where.err != nil || where.operator != OperatorEquals || !isIndexKey(where.fieldValue) {
+			continue
+		}
+
+		name := c.col.indexName(where.fieldName)
+		if _, ok := equalities[name]; !ok {
+			equalities[name] = where.fieldValue
+		}
+	}
+
+	if len(equalities) == 0 {
+		return nil, false, nil
+	}
+
+	fileNames, ok := c.col.lookupCompositeIndex(equalities)
+	if !ok {
+		return nil, false, nil
+	}
+
+	matchers, rest := []func(doc FlatDBModel[T]) (bool, error){}, []Query[T]{}
+	for _, q := range conjuncts {
+		if match, ok := queryMatcher(q); ok {
+			matchers = append(matchers, match)
+		} else {
+			rest = append(rest, q)
+		}
+	}
+
+	cur = &documentCursor[T]{
+		ctx:       ctx,
+		col:       c.col,
+		fileNames: fileNames,
+		match: func(doc FlatDBModel[T]) (bool, error) {
+			for _, match := range matchers {
+				if ok, err := match(doc); err != nil || !ok {
+					return false, err
+				}
+			}
+
+			return true, nil
+		},
 	}
 
-	result := []FlatDBModel[T]{}
+	for _, q := range rest {
+		ids, err := collectIDs(ctx, q)
+		if err != nil {
+			_ = cur.Close()
+			return nil, true, err
+		}
+
+		cur = &filterCursor[T]{
+			cur: cur,
+			match: func(doc FlatDBModel[T]) (bool, error) {
+				_, ok := ids[doc.ID]
+				return ok, nil
+			},
+		}
+	}
+
+	return cur, true, nil
+}
 
-	for _, doc := range rightResult {
-		if _, ok := leftSet[doc.ID]; ok {
-			result = append(result, doc)
+// conjuncts returns the queries joined by c and by nested and queries.
+func (c *AndQuery[T]) conjuncts() []Query[T] {
+	res := []Query[T]{}
+	for _, q := range []Query[T]{c.left, c.right} {
+		if and, ok := q.(*AndQuery[T]); ok {
+			res = append(res, and.conjuncts()...)
+		} else {
+			res = append(res, q)
 		}
 	}
 
-	return result, nil
+	return res
+}
+
+// sides returns the side of the query whose documents are read and the side they are filtered with.
+func (c *AndQuery[T]) sides() (driver Query[T], filter Query[T]) {
+	if _, ok := queryMatcher(c.right); !ok {
+		if _, ok := queryMatcher(c.left); ok {
+			return c.right, c.left
+		}
+	}
+
+	return c.left, c.right
 }
 
 type OrQuery[T any] struct {
@@ -186,36 +421,31 @@ type OrQuery[T any] struct {
 }
 
 func (c *OrQuery[T]) Execute() ([]FlatDBModel[T], error) {
-	leftResult, err := c.left.Execute()
-	if err != nil {
-		return nil, fmt.Errorf("error executing and query: %w", err)
-	}
+	return executeQuery[T](c)
+}
 
-	rightResult, err := c.right.Execute()
+// Iter merges both sides by id. If either side is not ordered by id, the left side is returned first,
+// followed by the documents of the right side not returned yet.
+func (c *OrQuery[T]) Iter(ctx context.Context) (Cursor[T], error) {
+	left, err := c.left.Iter(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("error executing and query: %w", err)
+		return nil, fmt.Errorf("error executing or query: %w", err)
 	}
 
-	leftSet := map[uint64]struct{}{}
-	for _, doc := range leftResult {
-		leftSet[doc.ID] = struct{}{}
+	right, err := c.right.Iter(ctx)
+	if err != nil {
+		_ = left.Close()
+		return nil, fmt.Errorf("error executing or query: %w", err)
 	}
 
-	result := []FlatDBModel[T]{}
-
-	for _, doc := range leftResult {
-		result = append(result, doc)
+	if isOrderedByID(c.left) && isOrderedByID(c.right) {
+		return &unionCursor[T]{left: left, right: right}, nil
 	}
 
-	for _, doc := range rightResult {
-		if _, ok := leftSet[doc.ID]; ok {
-			continue
-		}
-
-		result = append(result, doc)
-	}
-
-	return result, nil
+	return &distinctCursor[T]{
+		cursors: []Cursor[T]{left, right},
+		seen:    map[uint64]struct{}{},
+	}, nil
 }
 
 type LimitQuery[T any] struct {
@@ -227,22 +457,16 @@ type LimitQuery[T any] struct {
 }
 
 func (c *LimitQuery[T]) Execute() ([]FlatDBModel[T], error) {
-	docs, err := c.q.Execute()
+	return executeQuery[T](c)
+}
+
+func (c *LimitQuery[T]) Iter(ctx context.Context) (Cursor[T], error) {
+	cur, err := c.q.Iter(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("error executing limit query: %w", err)
 	}
 
-	result := make([]FlatDBModel[T], 0, c.limit)
-
-	n := c.limit
-	if len(docs) < c.limit {
-		n = len(result)
-	}
-	for i := 0; i < n; i++ {
-		result = append(result, docs[i])
-	}
-
-	return result, nil
+	return &limitCursor[T]{cur: cur, limit: c.limit}, nil
 }
 
 type OffsetQuery[T any] struct {
@@ -254,16 +478,60 @@ type OffsetQuery[T any] struct {
 }
 
 func (c *OffsetQuery[T]) Execute() ([]FlatDBModel[T], error) {
-	docs, err := c.q.Execute()
+	return executeQuery[T](c)
+}
+
+func (c *OffsetQuery[T]) Iter(ctx context.Context) (Cursor[T], error) {
+	cur, err := c.q.Iter(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("error executing limit query: %w", err)
+		return nil, fmt.Errorf("error executing offset query: %w", err)
+	}
+
+	return &offsetCursor[T]{cur: cur, offset: c.offset}, nil
+}
+
+type OrderByQuery[T any] struct {
+	col *FlatDBCollection[T]
+
+	q Query[T]
+
+	keys []orderKey
+
+	err error
+}
+
+func (c *OrderByQuery[T]) Execute() ([]FlatDBModel[T], error) {
+	return executeQuery[T](c)
+}
+
+// Iter walks the ordered index on the first key when the whole collection is selected, otherwise it sorts the results.
+func (c *OrderByQuery[T]) Iter(ctx context.Context) (Cursor[T], error) {
+	if c.err != nil {
+		return nil, fmt.Errorf("error executing order by query: %w", c.err)
+	}
+
+	if _, ok := c.q.(*SelectQuery[T]); ok {
+		if groups, ok := c.col.orderedIndexGroups(c.keys[0]); ok {
+			return &groupCursor[T]{
+				ctx:    ctx,
+				col:    c.col,
+				groups: groups,
+				keys:   c.keys[1:],
+			}, nil
+		}
+	}
+
+	cur, err := c.q.Iter(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error executing order by query: %w", err)
 	}
 
-	if len(docs) == 0 || c.offset >= len(docs) {
-		return []FlatDBModel[T]{}, nil
+	cur, err = c.col.orderedCursor(ctx, cur, c.keys)
+	if err != nil {
+		return nil, fmt.Errorf("error executing order by query: %w", err)
 	}
 
-	return docs[c.offset:], nil
+	return cur, nil
 }
 
 type SelectQuery[T any] struct {
@@ -271,18 +539,116 @@ type SelectQuery[T any] struct {
 }
 
 func (c *SelectQuery[T]) Execute() ([]FlatDBModel[T], error) {
-	docs, err := c.col.findAll()
+	return executeQuery[T](c)
+}
+
+func (c *SelectQuery[T]) Iter(ctx context.Context) (Cursor[T], error) {
+	c.col.logger.Info("running full scan")
+
+	cur, err := c.col.scanCursor(ctx, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error executing select query: %w", err)
 	}
 
-	return docs, nil
+	return cur, nil
+}
+
+// queryMatcher returns a function testing whether a single document is part of the results of q,
+// ok is false if q can't be evaluated document by document.
+func queryMatcher[T any](q Query[T]) (match func(doc FlatDBModel[T]) (bool, error), ok bool) {
+	switch q := q.(type) {
+	case *NopQuery[T]:
+		return func(FlatDBModel[T]) (bool, error) {
+			return false, nil
+		}, true
+	case *SelectQuery[T]:
+		return func(FlatDBModel[T]) (bool, error) {
+			return true, nil
+		}, true
+	case *WhereQuery[T]:
+		return func(doc FlatDBModel[T]) (bool, error) {
+			if q.err != nil {
+				return false, fmt.Errorf("error executing where query: %w", q.err)
+			}
+
+			return matchValues(q.operator, q.path.lookup(doc), q.fieldValue)
+		}, true
+	case *AndQuery[T]:
+		left, leftOk := queryMatcher(q.left)
+		right, rightOk := queryMatcher(q.right)
+		if !leftOk || !rightOk {
+			return nil, false
+		}
+
+		return func(doc FlatDBModel[T]) (bool, error) {
+			ok, err := left(doc)
+			if err != nil || !ok {
+				return false, err
+			}
+
+			return right(doc)
+		}, true
+	case *OrQuery[T]:
+		left, leftOk := queryMatcher(q.left)
+		right, rightOk := queryMatcher(q.right)
+		if !leftOk || !rightOk {
+			return nil, false
+		}
+
+		return func(doc FlatDBModel[T]) (bool, error) {
+			ok, err := left(doc)
+			if err != nil || ok {
+				return ok, err
+			}
+
+			return right(doc)
+		}, true
+	}
+
+	return nil, false
+}
+
+// isOrderedByID reports whether the results of q are known to be ordered by id.
+func isOrderedByID[T any](q Query[T]) bool {
+	switch q := q.(type) {
+	case *NopQuery[T], *SelectQuery[T], *WhereQuery[T]:
+		return true
+	case *AndQuery[T]:
+		driver, _ := q.sides()
+		return isOrderedByID(driver)
+	case *OrQuery[T]:
+		return isOrderedByID(q.left) && isOrderedByID(q.right)
+	case *LimitQuery[T]:
+		return isOrderedByID(q.q)
+	case *OffsetQuery[T]:
+		return isOrderedByID(q.q)
+	}
+
+	return false
+}
+
+// collectIDs runs q and returns the ids of its results.
+func collectIDs[T any](ctx context.Context, q Query[T]) (map[uint64]struct{}, error) {
+	cur, err := q.Iter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = cur.Close()
+	}()
+
+	ids := map[uint64]struct{}{}
+	for cur.Next() {
+		ids[cur.Doc().ID] = struct{}{}
+	}
+
+	return ids, cur.Err()
 }
 
 func parseOperator(op string) (QueryOperator, error) {
 	qOp, ok := operators[op]
 	if !ok {
-		return QueryOperator(0), fmt.Errorf("error parsing operator %s", op)
+		return QueryOperator(0), fmt.Errorf("%w: error parsing operator %s", InvalidQuery, op)
 	}
 
 	return qOp, nil
@@ -3,7 +3,10 @@ package goflatdb
 import (
 	"fmt"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
@@ -436,6 +439,607 @@ func TestQuery(t *testing.T) {
 	})
 }
 
+type rangeQueryTestData struct {
+	Foo int    `json:"foo"`
+	Bar string `json:"bar"`
+}
+
+func TestRangeQuery(t *testing.T) {
+	for _, withIndex := range []bool{false, true} {
+		withIndex := withIndex
+		t.Run(fmt.Sprintf("with ordered index %t", withIndex), func(t *testing.T) {
+			dir := t.TempDir()
+
+			logger, err := zap.NewDevelopment()
+			require.NoError(t, err)
+
+			db, err := NewFlatDB(dir, logger)
+			require.NoError(t, err)
+
+			opts := []FlatDBCollectionOption[rangeQueryTestData]{}
+			if withIndex {
+				opts = append(opts, WithOrderedIndex[rangeQueryTestData]("Foo"), WithOrderedIndex[rangeQueryTestData]("Bar"))
+			}
+
+			col, err := NewFlatDBCollection[rangeQueryTestData](db, "test-collection", logger, opts...)
+			require.NoError(t, err)
+
+			for i := 0; i < 1000; i++ {
+				_, err := col.Insert(&rangeQueryTestData{Foo: rand.Intn(100), Bar: fmt.Sprintf("%03d", rand.Intn(100))})
+				require.NoError(t, err)
+			}
+
+			all, err := col.QueryBuilder().Select().Execute()
+			require.NoError(t, err)
+
+			t.Run("less", func(t *testing.T) {
+				docs, err := col.QueryBuilder().Where("Foo", "<", 50).Execute()
+				require.NoError(t, err)
+
+				expected := []FlatDBModel[rangeQueryTestData]{}
+				for _, doc := range all {
+					if doc.Data.Foo < 50 {
+						expected = append(expected, doc)
+					}
+				}
+				require.True(t, checkEqual(expected, docs))
+			})
+
+			t.Run("more", func(t *testing.T) {
+				docs, err := col.QueryBuilder().Where("Bar", ">", "050").Execute()
+				require.NoError(t, err)
+
+				expected := []FlatDBModel[rangeQueryTestData]{}
+				for _, doc := range all {
+					if doc.Data.Bar > "050" {
+						expected = append(expected, doc)
+					}
+				}
+				require.True(t, checkEqual(expected, docs))
+			})
+
+			t.Run("range as and of less and more", func(t *testing.T) {
+				docs, err := col.
+					QueryBuilder().
+					Where("Foo", ">", 10).
+					And(col.QueryBuilder().Where("Foo", "<", 20)).
+					Execute()
+				require.NoError(t, err)
+
+				for _, doc := range docs {
+					require.True(t, doc.Data.Foo > 10 && doc.Data.Foo < 20)
+				}
+			})
+
+			t.Run("equality", func(t *testing.T) {
+				docs, err := col.QueryBuilder().Where("Foo", "=", all[0].Data.Foo).Execute()
+				require.NoError(t, err)
+
+				for _, doc := range docs {
+					require.Equal(t, all[0].Data.Foo, doc.Data.Foo)
+				}
+				require.NotEmpty(t, docs)
+			})
+
+			t.Run("index stays consistent after update and delete", func(t *testing.T) {
+				require.NoError(t, col.Update(1, &rangeQueryTestData{Foo: -1}))
+				require.NoError(t, col.Delete(2))
+
+				docs, err := col.QueryBuilder().Where("Foo", "<", 0).Execute()
+				require.NoError(t, err)
+				require.Equal(t, 1, len(docs))
+				require.Equal(t, uint64(1), docs[0].ID)
+
+				docs, err = col.QueryBuilder().Where("Foo", ">", -100).Execute()
+				require.NoError(t, err)
+				require.Equal(t, len(all)-1, len(docs))
+			})
+		})
+	}
+}
+
+type operatorsTestData struct {
+	Int    int       `json:"int"`
+	Float  float64   `json:"float"`
+	String string    `json:"string"`
+	Bool   bool      `json:"bool"`
+	Time   time.Time `json:"time"`
+	Tags   []string  `json:"tags"`
+	Ptr    *int      `json:"ptr"`
+}
+
+func TestOperators(t *testing.T) {
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	one := 1
+
+	data := []operatorsTestData{
+		{Int: 1, Float: 1.5, String: "apple", Bool: true, Time: base, Tags: []string{"a", "b"}, Ptr: &one},
+		{Int: 2, Float: 2.5, String: "apricot", Bool: false, Time: base.Add(time.Hour), Tags: []string{"b"}},
+		{Int: 3, Float: 3.5, String: "banana", Bool: true, Time: base.Add(2 * time.Hour), Tags: nil},
+		{Int: 4, Float: 4.5, String: "cherry", Bool: false, Time: base.Add(3 * time.Hour), Tags: []string{"c"}},
+	}
+
+	testCases := []struct {
+		field    string
+		operator string
+		value    interface{}
+		expected []uint64
+	}{
+		{"Int", "=", 2, []uint64{2}},
+		{"Int", "!=", 2, []uint64{1, 3, 4}},
+		{"Int", "<=", 2, []uint64{1, 2}},
+		{"Int", ">=", 3, []uint64{3, 4}},
+		{"Float", "<", 2.5, []uint64{1}},
+		{"Float", ">", 2.5, []uint64{3, 4}},
+		{"String", "<", "b", []uint64{1, 2}},
+		{"Bool", "=", true, []uint64{1, 3}},
+		{"Bool", ">", false, []uint64{1, 3}},
+		{"Time", ">=", base.Add(2 * time.Hour), []uint64{3, 4}},
+		{"Time", "between", []time.Time{base.Add(time.Hour), base.Add(2 * time.Hour)}, []uint64{2, 3}},
+		{"Int", "in", []int{1, 4, 5}, []uint64{1, 4}},
+		{"Int", "not in", []int{1, 4, 5}, []uint64{2, 3}},
+		{"Int", "between", []int{2, 3}, []uint64{2, 3}},
+		{"String", "in", []interface{}{"apple", "cherry"}, []uint64{1, 4}},
+		{"String", "prefix", "ap", []uint64{1, 2}},
+		{"String", "contains", "an", []uint64{3}},
+		{"Tags", "contains", "b", []uint64{1, 2}},
+		{"String", "regex", "^(apple|banana)$", []uint64{1, 3}},
+		{"Ptr", "is null", nil, []uint64{2, 3, 4}},
+		{"Ptr", "is null", false, []uint64{1}},
+		{"Tags", "is null", nil, []uint64{3}},
+		{"Int", "exists", nil, []uint64{1, 2, 3, 4}},
+		{"Missing", "exists", nil, []uint64{}},
+		{"Missing", "exists", false, []uint64{1, 2, 3, 4}},
+		{"Missing", "=", 1, []uint64{}},
+	}
+
+	for _, withIndex := range []bool{false, true} {
+		withIndex := withIndex
+		t.Run(fmt.Sprintf("with ordered index %t", withIndex), func(t *testing.T) {
+			dir := t.TempDir()
+
+			logger, err := zap.NewDevelopment()
+			require.NoError(t, err)
+
+			db, err := NewFlatDB(dir, logger)
+			require.NoError(t, err)
+
+			opts := []FlatDBCollectionOption[operatorsTestData]{}
+			if withIndex {
+				for _, field := range []string{"Int", "Float", "String", "Bool", "Time"} {
+					opts = append(opts, WithOrderedIndex[operatorsTestData](field))
+				}
+			}
+
+			col, err := NewFlatDBCollection[operatorsTestData](db, "test-collection", logger, opts...)
+			require.NoError(t, err)
+
+			for i := range data {
+				_, err := col.Insert(&data[i])
+				require.NoError(t, err)
+			}
+
+			for _, tc := range testCases {
+				tc := tc
+				t.Run(fmt.Sprintf("%s %s %v", tc.field, tc.operator, tc.value), func(t *testing.T) {
+					docs, err := col.QueryBuilder().Where(tc.field, tc.operator, tc.value).Execute()
+					require.NoError(t, err)
+
+					ids := []uint64{}
+					for _, doc := range docs {
+						ids = append(ids, doc.ID)
+					}
+					require.ElementsMatch(t, tc.expected, ids)
+				})
+			}
+		})
+	}
+
+	t.Run("operands that can't be index keys", func(t *testing.T) {
+		dir := t.TempDir()
+
+		logger, err := zap.NewDevelopment()
+		require.NoError(t, err)
+
+		db, err := NewFlatDB(dir, logger)
+		require.NoError(t, err)
+
+		col, err := NewFlatDBCollection[operatorsTestData](db, "test-collection", logger,
+			WithUnorderedIndex[operatorsTestData]("Int"),
+			WithUnorderedIndex[operatorsTestData]("Tags"),
+			WithCompositeIndex[operatorsTestData]("Int", "String"),
+		)
+		require.NoError(t, err)
+
+		for i := range data {
+			_, err := col.Insert(&data[i])
+			require.NoError(t, err)
+		}
+
+		for _, tc := range []struct {
+			query    *QueryBuilder[operatorsTestData]
+			expected []uint64
+		}{
+			{col.QueryBuilder().Where("Int", "=", []int{1}), []uint64{}},
+			{col.QueryBuilder().Where("Int", "in", []interface{}{[]int{1}, 2}), []uint64{2}},
+			{col.QueryBuilder().Where("Tags", "=", map[string]int{"a": 1}), []uint64{}},
+			{col.QueryBuilder().Where("Int", "=", []int{1}).And(col.QueryBuilder().Where("String", "=", "apple")), []uint64{}},
+		} {
+			docs, err := tc.query.Execute()
+			require.NoError(t, err)
+
+			ids := []uint64{}
+			for _, doc := range docs {
+				ids = append(ids, doc.ID)
+			}
+			require.ElementsMatch(t, tc.expected, ids)
+		}
+	})
+
+	t.Run("invalid operands are rejected", func(t *testing.T) {
+		dir := t.TempDir()
+
+		logger, err := zap.NewDevelopment()
+		require.NoError(t, err)
+
+		db, err := NewFlatDB(dir, logger)
+		require.NoError(t, err)
+
+		col, err := NewFlatDBCollection[operatorsTestData](db, "test-collection", logger)
+		require.NoError(t, err)
+
+		for _, tc := range []struct {
+			operator string
+			value    interface{}
+		}{
+			{"in", 1},
+			{"between", []int{1}},
+			{"prefix", 1},
+			{"regex", "("},
+			{"exists", "yes"},
+			{">", []int{1}},
+			{"between", []interface{}{1, "a"}},
+			{"~", 1},
+		} {
+			_, err := col.QueryBuilder().Where("Int", tc.operator, tc.value).Execute()
+			require.Error(t, err)
+		}
+	})
+}
+
+type coercionTestKind string
+
+type coercionTestData struct {
+	Age   int64            `json:"age"`
+	Small uint8            `json:"small"`
+	Score float32          `json:"score"`
+	Kind  coercionTestKind `json:"kind"`
+}
+
+func TestQueryNumericCoercion(t *testing.T) {
+	testCases := []struct {
+		field    string
+		operator string
+		value    interface{}
+		expected []uint64
+	}{
+		{"Age", "=", 5, []uint64{1}},
+		{"Age", "=", uint16(5), []uint64{1}},
+		{"Age", "=", 5.0, []uint64{1}},
+		{"Age", "in", []interface{}{5, int8(7)}, []uint64{1, 2}},
+		{"Age", ">", 5.5, []uint64{2}},
+		{"Small", "=", 3, []uint64{1}},
+		{"Small", "<", int64(4), []uint64{1}},
+		{"Score", "=", 2, []uint64{2}},
+		{"Score", "=", 1.5, []uint64{1}},
+		{"Kind", "=", "a", []uint64{1}},
+		{"Kind", "in", []string{"a", "b"}, []uint64{1, 2}},
+	}
+
+	for _, withIndex := range []string{"none", "unordered", "ordered"} {
+		withIndex := withIndex
+		t.Run(fmt.Sprintf("with %s index", withIndex), func(t *testing.T) {
+			dir := t.TempDir()
+
+			logger, err := zap.NewDevelopment()
+			require.NoError(t, err)
+
+			db, err := NewFlatDB(dir, logger)
+			require.NoError(t, err)
+
+			opts := []FlatDBCollectionOption[coercionTestData]{}
+			for _, field := range []string{"Age", "Small", "Score", "Kind"} {
+				switch withIndex {
+				case "unordered":
+					opts = append(opts, WithUnorderedIndex[coercionTestData](field))
+				case "ordered":
+					opts = append(opts, WithOrderedIndex[coercionTestData](field))
+				}
+			}
+
+			col, err := NewFlatDBCollection[coercionTestData](db, "test-collection", logger, opts...)
+			require.NoError(t, err)
+
+			_, err = col.Insert(&coercionTestData{Age: 5, Small: 3, Score: 1.5, Kind: "a"})
+			require.NoError(t, err)
+			_, err = col.Insert(&coercionTestData{Age: 7, Small: 9, Score: 2, Kind: "b"})
+			require.NoError(t, err)
+
+			for _, tc := range testCases {
+				tc := tc
+				t.Run(fmt.Sprintf("%s %s %v", tc.field, tc.operator, tc.value), func(t *testing.T) {
+					docs, err := col.QueryBuilder().Where(tc.field, tc.operator, tc.value).Execute()
+					require.NoError(t, err)
+
+					ids := []uint64{}
+					for _, doc := range docs {
+						ids = append(ids, doc.ID)
+					}
+					require.ElementsMatch(t, tc.expected, ids)
+				})
+			}
+		})
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	for _, withIndex := range []bool{false, true} {
+		withIndex := withIndex
+		t.Run(fmt.Sprintf("with ordered index %t", withIndex), func(t *testing.T) {
+			dir := t.TempDir()
+
+			logger, err := zap.NewDevelopment()
+			require.NoError(t, err)
+
+			db, err := NewFlatDB(dir, logger)
+			require.NoError(t, err)
+
+			opts := []FlatDBCollectionOption[rangeQueryTestData]{}
+			if withIndex {
+				opts = append(opts, WithOrderedIndex[rangeQueryTestData]("Foo"))
+			}
+
+			col, err := NewFlatDBCollection[rangeQueryTestData](db, "test-collection", logger, opts...)
+			require.NoError(t, err)
+
+			for i := 0; i < 100; i++ {
+				_, err := col.Insert(&rangeQueryTestData{Foo: rand.Intn(10), Bar: fmt.Sprintf("%d", rand.Intn(10))})
+				require.NoError(t, err)
+			}
+
+			t.Run("results are ordered by id by default", func(t *testing.T) {
+				docs, err := col.QueryBuilder().Select().Execute()
+				require.NoError(t, err)
+				require.Equal(t, 100, len(docs))
+				for i, doc := range docs {
+					require.Equal(t, uint64(i+1), doc.ID)
+				}
+			})
+
+			t.Run("single key", func(t *testing.T) {
+				for _, direction := range []SortDirection{Asc, Desc} {
+					docs, err := col.QueryBuilder().Select().OrderBy("Foo", direction).Execute()
+					require.NoError(t, err)
+					require.Equal(t, 100, len(docs))
+
+					for i := 1; i < len(docs); i++ {
+						prev, cur := docs[i-1], docs[i]
+						if prev.Data.Foo == cur.Data.Foo {
+							require.Less(t, prev.ID, cur.ID)
+						} else if direction == Asc {
+							require.Less(t, prev.Data.Foo, cur.Data.Foo)
+						} else {
+							require.Greater(t, prev.Data.Foo, cur.Data.Foo)
+						}
+					}
+				}
+			})
+
+			t.Run("multiple keys", func(t *testing.T) {
+				docs, err := col.QueryBuilder().Select().OrderBy("Foo", Desc).OrderBy("Bar", Asc).Execute()
+				require.NoError(t, err)
+				require.Equal(t, 100, len(docs))
+
+				for i := 1; i < len(docs); i++ {
+					prev, cur := docs[i-1], docs[i]
+					switch {
+					case prev.Data.Foo != cur.Data.Foo:
+						require.Greater(t, prev.Data.Foo, cur.Data.Foo)
+					case prev.Data.Bar != cur.Data.Bar:
+						require.Less(t, prev.Data.Bar, cur.Data.Bar)
+					default:
+						require.Less(t, prev.ID, cur.ID)
+					}
+				}
+			})
+
+			t.Run("filtered", func(t *testing.T) {
+				docs, err := col.QueryBuilder().Where("Foo", ">=", 5).OrderBy("Bar", Desc).Execute()
+				require.NoError(t, err)
+
+				for i, doc := range docs {
+					require.GreaterOrEqual(t, doc.Data.Foo, 5)
+					if i > 0 {
+						require.GreaterOrEqual(t, docs[i-1].Data.Bar, doc.Data.Bar)
+					}
+				}
+			})
+
+			t.Run("pagination", func(t *testing.T) {
+				all, err := col.QueryBuilder().Select().OrderBy("Foo", Asc).Execute()
+				require.NoError(t, err)
+
+				paged := []FlatDBModel[rangeQueryTestData]{}
+				for offset := 0; offset < 100; offset += 30 {
+					page, err := col.QueryBuilder().Select().OrderBy("Foo", Asc).Offset(offset).Limit(30).Execute()
+					require.NoError(t, err)
+					paged = append(paged, page...)
+				}
+
+				require.Equal(t, all, paged)
+			})
+		})
+	}
+
+	t.Run("ordered index on a slice field", func(t *testing.T) {
+		type orderSliceTestData struct {
+			Tags []string
+			Raw  []byte
+		}
+
+		dir := t.TempDir()
+
+		logger, err := zap.NewDevelopment()
+		require.NoError(t, err)
+
+		db, err := NewFlatDB(dir, logger)
+		require.NoError(t, err)
+
+		col, err := NewFlatDBCollection[orderSliceTestData](db, "test-collection", logger,
+			WithOrderedIndex[orderSliceTestData]("Tags"),
+			WithOrderedIndex[orderSliceTestData]("Raw"))
+		require.NoError(t, err)
+
+		_, err = col.Insert(&orderSliceTestData{Tags: []string{"x", "y"}, Raw: []byte("b")})
+		require.NoError(t, err)
+		_, err = col.Insert(&orderSliceTestData{Raw: []byte("a")})
+		require.NoError(t, err)
+
+		for _, fieldName := range []string{"Tags", "Raw"} {
+			docs, err := col.QueryBuilder().Select().OrderBy(fieldName, Asc).Execute()
+			require.NoError(t, err)
+
+			ids := []uint64{}
+			for _, doc := range docs {
+				ids = append(ids, doc.ID)
+			}
+			require.ElementsMatch(t, []uint64{1, 2}, ids, fieldName)
+		}
+	})
+}
+
+type compositeIndexTestData struct {
+	TenantID int
+	Status   string
+	Name     string
+}
+
+func TestCompositeIndex(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	db, err := NewFlatDB(dir, logger)
+	require.NoError(t, err)
+
+	col, err := NewFlatDBCollection[compositeIndexTestData](db, "test-collection", logger,
+		WithCompositeIndex[compositeIndexTestData]("TenantID", "Status"))
+	require.NoError(t, err)
+
+	for i := 0; i < 60; i++ {
+		_, err := col.Insert(&compositeIndexTestData{
+			TenantID: i % 3,
+			Status:   []string{"active", "inactive"}[i%2],
+			Name:     fmt.Sprintf("name%d", i),
+		})
+		require.NoError(t, err)
+	}
+
+	// document 3 belongs to tenant 2, none of the queries below may read it
+	err = os.WriteFile(filepath.Join(dir, "test-collection", col.documentFileName(3)), []byte(`{"data":`), 0666)
+	require.NoError(t, err)
+
+	queryIDs := func(t *testing.T, q *QueryBuilder[compositeIndexTestData]) []uint64 {
+		docs, err := q.Execute()
+		require.NoError(t, err)
+
+		ids := []uint64{}
+		for _, doc := range docs {
+			ids = append(ids, doc.ID)
+		}
+		return ids
+	}
+
+	t.Run("full index", func(t *testing.T) {
+		ids := queryIDs(t, col.QueryBuilder().
+			Where("TenantID", "=", 0).
+			And(col.QueryBuilder().Where("Status", "=", "active")))
+		require.Equal(t, []uint64{1, 7, 13, 19, 25, 31, 37, 43, 49, 55}, ids)
+
+		ids = queryIDs(t, col.QueryBuilder().
+			Where("Status", "=", "inactive").
+			And(col.QueryBuilder().Where("TenantID", "=", int64(1))))
+		require.Equal(t, []uint64{2, 8, 14, 20, 26, 32, 38, 44, 50, 56}, ids)
+	})
+
+	t.Run("prefix", func(t *testing.T) {
+		ids := queryIDs(t, col.QueryBuilder().
+			Where("TenantID", "=", 1).
+			And(col.QueryBuilder().Where("Name", "prefix", "name1")))
+		require.Equal(t, []uint64{2, 11, 14, 17, 20}, ids)
+	})
+
+	t.Run("nested and with a non matchable side", func(t *testing.T) {
+		ids := queryIDs(t, col.QueryBuilder().
+			Where("TenantID", "=", 0).
+			And(col.QueryBuilder().Where("Status", "=", "active")).
+			And(col.QueryBuilder().
+				Where("TenantID", "=", 0).
+				And(col.QueryBuilder().Where("Name", "<", "name3")).
+				Limit(4)))
+		require.Equal(t, []uint64{1, 13, 19}, ids)
+	})
+
+	t.Run("writes update the index", func(t *testing.T) {
+		err := col.Update(1, &compositeIndexTestData{TenantID: 0, Status: "inactive", Name: "name0"})
+		require.NoError(t, err)
+		err = col.Delete(7)
+		require.NoError(t, err)
+
+		ids := queryIDs(t, col.QueryBuilder().
+			Where("TenantID", "=", 0).
+			And(col.QueryBuilder().Where("Status", "=", "active")))
+		require.Equal(t, []uint64{13, 19, 25, 31, 37, 43, 49, 55}, ids)
+	})
+
+	t.Run("equalities not covering a prefix scan the collection", func(t *testing.T) {
+		_, err := col.QueryBuilder().
+			Where("Status", "=", "active").
+			And(col.QueryBuilder().Where("Name", "=", "name0")).
+			Execute()
+		require.Error(t, err)
+	})
+}
+
+func TestSkipList(t *testing.T) {
+	l := newSkipList()
+
+	keys := rand.Perm(1000)
+	for _, k := range keys {
+		l.add(k, fmt.Sprintf("%d.json", k))
+	}
+	for _, k := range keys[:500] {
+		l.remove(k, fmt.Sprintf("%d.json", k))
+	}
+
+	remaining := []int{}
+	for node := l.head.next[0]; node != nil; node = node.next[0] {
+		remaining = append(remaining, node.key.(int))
+	}
+
+	require.Equal(t, 500, len(remaining))
+	for i := 1; i < len(remaining); i++ {
+		require.Less(t, remaining[i-1], remaining[i])
+	}
+	for _, k := range keys[500:] {
+		require.NotNil(t, l.get(k))
+	}
+	for _, k := range keys[:500] {
+		require.Nil(t, l.get(k))
+	}
+}
+
 func checkEqual[T any](t1 []FlatDBModel[T], t2 []FlatDBModel[T]) bool {
 	if len(t1) != len(t2) {
 		return false
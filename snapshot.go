@@ -0,0 +1,376 @@
+package goflatdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	indexSnapshotFileName = "indexes.snapshot"
+	indexSnapshotVersion  = 1
+)
+
+const (
+	snapshotIndexUnordered = "unordered"
+	snapshotIndexUnique    = "unique"
+	snapshotIndexOrdered   = "ordered"
+	snapshotIndexComposite = "composite"
+)
+
+// indexSnapshot holds the contents of all indexes of a collection as of HighWaterID: every document
+// with a higher id was inserted after the snapshot was taken. It is stored as [payload crc32][payload].
+// Updates and deletes remove the snapshot, as they may change documents it covers. The keys are taken from
+// the documents migrated to SchemaVersion, so the snapshot is ignored once the collection has other migrations.
+type indexSnapshot struct {
+	Version       int                  `json:"version"`
+	SchemaVersion uint64               `json:"schemaVersion,omitempty"`
+	HighWaterID   uint64               `json:"highWaterID"`
+	Indexes       []indexSnapshotIndex `json:"indexes"`
+}
+
+type indexSnapshotIndex struct {
+	Kind       string               `json:"kind"`
+	FieldNames []string             `json:"fieldNames"`
+	Entries    []indexSnapshotEntry `json:"entries"`
+}
+
+type indexSnapshotEntry struct {
+	Key snapshotKey `json:"key"`
+	IDs []uint64    `json:"ids"`
+}
+
+// snapshotKey is a normalized index key tagged with its type, so it decodes to the same key.
+type snapshotKey struct {
+	Kind  string        `json:"k"`
+	Value string        `json:"v,omitempty"`
+	Elems []snapshotKey `json:"e,omitempty"`
+}
+
+// encodeSnapshotKey returns the snapshotKey of key. ok is false if key has a type that can't be stored.
+func encodeSnapshotKey(key interface{}) (snapshotKey, bool) {
+	switch key := key.(type) {
+	case nil:
+		return snapshotKey{Kind: "nil"}, true
+	case int64:
+		return snapshotKey{Kind: "int", Value: strconv.FormatInt(key, 10)}, true
+	case uint64:
+		return snapshotKey{Kind: "uint", Value: strconv.FormatUint(key, 10)}, true
+	case float64:
+		return snapshotKey{Kind: "float", Value: strconv.FormatFloat(key, 'g', -1, 64)}, true
+	case string:
+		return snapshotKey{Kind: "string", Value: key}, true
+	case bool:
+		return snapshotKey{Kind: "bool", Value: strconv.FormatBool(key)}, true
+	case time.Time:
+		return snapshotKey{Kind: "time", Value: key.Format(time.RFC3339Nano)}, true
+	case compositeKey:
+		elems := make([]snapshotKey, len(key))
+		for i, elem := range key {
+			var ok bool
+			if elems[i], ok = encodeSnapshotKey(elem); !ok {
+				return snapshotKey{}, false
+			}
+		}
+		return snapshotKey{Kind: "composite", Elems: elems}, true
+	}
+
+	return snapshotKey{}, false
+}
+
+func decodeSnapshotKey(key snapshotKey) (interface{}, error) {
+	switch key.Kind {
+	case "nil":
+		return nil, nil
+	case "int":
+		return strconv.ParseInt(key.Value, 10, 64)
+	case "uint":
+		return strconv.ParseUint(key.Value, 10, 64)
+	case "float":
+		return strconv.ParseFloat(key.Value, 64)
+	case "string":
+		return key.Value, nil
+	case "bool":
+		return strconv.ParseBool(key.Value)
+	case "time":
+		t, err := time.Parse(time.RFC3339Nano, key.Value)
+		if err != nil {
+			return nil, err
+		}
+		return t.UTC(), nil
+	case "composite":
+		res := make(compositeKey, len(key.Elems))
+		for i, elem := range key.Elems {
+			var err error
+			if res[i], err = decodeSnapshotKey(elem); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	}
+
+	return nil, fmt.Errorf("unknown snapshot key kind %q", key.Kind)
+}
+
+// writeIndexSnapshot stores the current contents of all indexes. Indexes holding keys that can't be stored
+// are not snapshotted, so the next Init rebuilds them from the documents. Caller must hold c.mu.
+func (c *FlatDBCollection[T]) writeIndexSnapshot() error {
+	highWaterID, err := readID(c.idFile)
+	if err != nil {
+		return errorWritingIndexSnapshot(err)
+	}
+
+	snapshot := indexSnapshot{
+		Version:       indexSnapshotVersion,
+		SchemaVersion: c.schemaVersion(),
+		HighWaterID:   highWaterID,
+		Indexes:       []indexSnapshotIndex{},
+	}
+
+	ok := true
+	addEntry := func(index *indexSnapshotIndex, key interface{}, fileNames []string) {
+		encoded, encodable := encodeSnapshotKey(key)
+		if !encodable {
+			ok = false
+			return
+		}
+
+		ids := make([]uint64, len(fileNames))
+		for i, fileName := range fileNames {
+			ids[i] = documentIDFromFileName(fileName)
+		}
+
+		index.Entries = append(index.Entries, indexSnapshotEntry{Key: encoded, IDs: ids})
+	}
+
+	for _, idx := range c.unorderedIndexes {
+		index := indexSnapshotIndex{Kind: snapshotIndexUnordered, FieldNames: []string{idx.fieldName}}
+		if idx.unique {
+			index.Kind = snapshotIndexUnique
+		}
+
+		for key, fileNames := range idx.data {
+			addEntry(&index, key, fileNames)
+		}
+
+		snapshot.Indexes = append(snapshot.Indexes, index)
+	}
+
+	for _, idx := range c.orderedIndexes {
+		index := indexSnapshotIndex{Kind: snapshotIndexOrdered, FieldNames: []string{idx.fieldName}}
+		idx.scan(nil, nil, func(key interface{}, fileNames []string) bool {
+			addEntry(&index, key, fileNames)
+			return ok
+		})
+
+		snapshot.Indexes = append(snapshot.Indexes, index)
+	}
+
+	for _, idx := range c.compositeIndexes {
+		index := indexSnapshotIndex{Kind: snapshotIndexComposite, FieldNames: idx.fieldNames}
+		idx.scanPrefix(compositeKey{}, func(key compositeKey, fileNames []string) bool {
+			addEntry(&index, key, fileNames)
+			return ok
+		})
+
+		snapshot.Indexes = append(snapshot.Indexes, index)
+	}
+
+	if !ok {
+		c.logger.Info("not writing index snapshot, an index holds keys that can't be stored")
+		return nil
+	}
+
+	payload, err := json.Marshal(snapshot)
+	if err != nil {
+		return errorWritingIndexSnapshot(err)
+	}
+
+	data := make([]byte, 4, 4+len(payload))
+	binary.BigEndian.PutUint32(data, crc32.ChecksumIEEE(payload))
+	data = append(data, payload...)
+
+	if err := writeFileAtomic(c.dir, indexSnapshotFileName, data, c.durability); err != nil {
+		return errorWritingIndexSnapshot(err)
+	}
+
+	c.hasIndexSnapshot = true
+	c.indexSnapshotHighWaterID = highWaterID
+
+	return nil
+}
+
+// loadIndexSnapshot fills the indexes from the snapshot. ok is false if there is no usable snapshot,
+// in which case the indexes are left empty and have to be rebuilt from the documents.
+func (c *FlatDBCollection[T]) loadIndexSnapshot() (highWaterID uint64, ok bool) {
+	data, err := os.ReadFile(documentFilePath(c.dir.Name(), indexSnapshotFileName))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			c.logger.Error("error reading index snapshot, rebuilding indexes", zap.Error(err))
+		}
+		return 0, false
+	}
+
+	snapshot, err := decodeIndexSnapshot(data)
+	if err != nil {
+		c.logger.Error("error decoding index snapshot, rebuilding indexes", zap.Error(err))
+		return 0, false
+	}
+
+	// snapshots written before schema versions were introduced hold the keys of schema version 1
+	if snapshot.SchemaVersion == 0 {
+		snapshot.SchemaVersion = 1
+	}
+	if snapshot.SchemaVersion != c.schemaVersion() {
+		c.logger.Info("index snapshot was taken with another schema version, rebuilding indexes",
+			zap.Uint64("snapshotSchemaVersion", snapshot.SchemaVersion), zap.Uint64("schemaVersion", c.schemaVersion()))
+		return 0, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.applyIndexSnapshot(snapshot); err != nil {
+		c.logger.Info("index snapshot doesn't match the indexes of the collection, rebuilding indexes", zap.Error(err))
+		c.clearIndexes()
+		return 0, false
+	}
+
+	c.indexSnapshotHighWaterID = snapshot.HighWaterID
+
+	return snapshot.HighWaterID, true
+}
+
+func decodeIndexSnapshot(data []byte) (indexSnapshot, error) {
+	if len(data) < 4 {
+		return indexSnapshot{}, errors.New("index snapshot is truncated")
+	}
+
+	payload := data[4:]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(data[:4]) {
+		return indexSnapshot{}, errors.New("index snapshot checksum mismatch")
+	}
+
+	var snapshot indexSnapshot
+	if err := json.Unmarshal(payload, &snapshot); err != nil {
+		return indexSnapshot{}, err
+	}
+
+	if snapshot.Version != indexSnapshotVersion {
+		return indexSnapshot{}, fmt.Errorf("unsupported index snapshot version %d", snapshot.Version)
+	}
+
+	return snapshot, nil
+}
+
+// applyIndexSnapshot adds the entries of snapshot to the indexes. It fails unless snapshot holds exactly
+// the indexes of the collection. Caller must hold c.mu.
+func (c *FlatDBCollection[T]) applyIndexSnapshot(snapshot indexSnapshot) error {
+	if len(snapshot.Indexes) != len(c.unorderedIndexes)+len(c.orderedIndexes)+len(c.compositeIndexes) {
+		return errors.New("index count mismatch")
+	}
+
+	seen := map[string]bool{}
+	for _, index := range snapshot.Indexes {
+		name := compositeIndexName(index.FieldNames)
+		if len(index.FieldNames) == 0 || seen[index.Kind+":"+name] {
+			return fmt.Errorf("unexpected %s index on %v", index.Kind, index.FieldNames)
+		}
+		seen[index.Kind+":"+name] = true
+
+		var add func(key interface{}, fileName string)
+
+		switch index.Kind {
+		case snapshotIndexUnordered, snapshotIndexUnique:
+			idx := c.unorderedIndexes[index.FieldNames[0]]
+			if idx == nil || len(index.FieldNames) != 1 || idx.unique != (index.Kind == snapshotIndexUnique) {
+				return fmt.Errorf("unexpected %s index on %v", index.Kind, index.FieldNames)
+			}
+			add = idx.add
+		case snapshotIndexOrdered:
+			idx := c.orderedIndexes[index.FieldNames[0]]
+			if idx == nil || len(index.FieldNames) != 1 {
+				return fmt.Errorf("unexpected %s index on %v", index.Kind, index.FieldNames)
+			}
+			add = idx.add
+		case snapshotIndexComposite:
+			idx := c.compositeIndexes[name]
+			if idx == nil {
+				return fmt.Errorf("unexpected %s index on %v", index.Kind, index.FieldNames)
+			}
+			add = func(key interface{}, fileName string) {
+				idx.add(key.(compositeKey), fileName)
+			}
+		default:
+			return fmt.Errorf("unknown index kind %q", index.Kind)
+		}
+
+		for _, entry := range index.Entries {
+			key, err := decodeSnapshotKey(entry.Key)
+			if err != nil {
+				return err
+			}
+
+			if _, composite := key.(compositeKey); composite != (index.Kind == snapshotIndexComposite) {
+				return fmt.Errorf("unexpected key kind %q in %s index", entry.Key.Kind, index.Kind)
+			}
+
+			for _, id := range entry.IDs {
+				add(key, c.documentFileName(id))
+			}
+		}
+	}
+
+	return nil
+}
+
+// clearIndexes removes all entries from the indexes. Caller must hold c.mu.
+func (c *FlatDBCollection[T]) clearIndexes() {
+	for _, idx := range c.unorderedIndexes {
+		idx.data = map[interface{}][]string{}
+	}
+	for _, idx := range c.orderedIndexes {
+		idx.data = newSkipList()
+	}
+	for _, idx := range c.compositeIndexes {
+		idx.data = newSkipList()
+	}
+}
+
+// invalidateIndexSnapshot removes the index snapshot before document id is changed, if the snapshot covers it.
+// Caller must hold c.mu.
+func (c *FlatDBCollection[T]) invalidateIndexSnapshot(id uint64) error {
+	if !c.hasIndexSnapshot || id > c.indexSnapshotHighWaterID {
+		return nil
+	}
+
+	err := os.Remove(documentFilePath(c.dir.Name(), indexSnapshotFileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errorInvalidatingIndexSnapshot(err)
+	}
+
+	if c.durability >= DurabilityFileAndDirSync {
+		if err := c.dir.Sync(); err != nil {
+			return errorInvalidatingIndexSnapshot(err)
+		}
+	}
+
+	c.hasIndexSnapshot = false
+
+	return nil
+}
+
+func errorWritingIndexSnapshot(err error) error {
+	return fmt.Errorf("error writing index snapshot: %w", err)
+}
+
+func errorInvalidatingIndexSnapshot(err error) error {
+	return fmt.Errorf("error invalidating index snapshot: %w", err)
+}
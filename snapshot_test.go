@@ -0,0 +1,122 @@
+package goflatdb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type snapshotTestData struct {
+	Foo string
+	Bar int
+	Baz time.Time
+}
+
+func TestIndexSnapshot(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	db, err := NewFlatDB(dir, logger)
+	require.NoError(t, err)
+
+	colDir := filepath.Join(dir, "test-collection")
+	snapshotPath := filepath.Join(colDir, indexSnapshotFileName)
+
+	indexOpts := []FlatDBCollectionOption[snapshotTestData]{
+		WithUniqueIndex[snapshotTestData]("Foo"),
+		WithOrderedIndex[snapshotTestData]("Bar"),
+		WithOrderedIndex[snapshotTestData]("Baz"),
+		WithCompositeIndex[snapshotTestData]("Bar", "Foo"),
+	}
+
+	open := func(t *testing.T, opts ...FlatDBCollectionOption[snapshotTestData]) *FlatDBCollection[snapshotTestData] {
+		col, err := NewFlatDBCollection[snapshotTestData](db, "test-collection", logger, opts...)
+		require.NoError(t, err)
+		return col
+	}
+
+	queryIDs := func(t *testing.T, q *QueryBuilder[snapshotTestData]) []uint64 {
+		docs, err := q.Execute()
+		require.NoError(t, err)
+
+		ids := []uint64{}
+		for _, doc := range docs {
+			ids = append(ids, doc.ID)
+		}
+		return ids
+	}
+
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	foos := []string{"a", "b", "c", "d", "e"}
+
+	col := open(t, indexOpts...)
+	for i, foo := range foos {
+		_, err := col.Insert(&snapshotTestData{Foo: foo, Bar: i % 2, Baz: base.Add(time.Duration(i) * time.Hour)})
+		require.NoError(t, err)
+	}
+	require.NoError(t, col.Close())
+	require.FileExists(t, snapshotPath)
+
+	// inserts made while the indexes aren't loaded are not covered by the snapshot
+	col = open(t)
+	_, err = col.Insert(&snapshotTestData{Foo: "f", Bar: 1, Baz: base.Add(5 * time.Hour)})
+	require.NoError(t, err)
+	require.NoError(t, col.Close())
+
+	// a corrupt document covered by the snapshot is never read when the snapshot is loaded
+	doc1Path := filepath.Join(colDir, col.documentFileName(1))
+	doc1, err := os.ReadFile(doc1Path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(doc1Path, []byte(`{"data":`), 0666))
+
+	col = open(t, indexOpts...)
+
+	require.Equal(t, []uint64{2, 4, 6}, queryIDs(t, col.QueryBuilder().Where("Bar", "=", 1)))
+	require.Equal(t, []uint64{5, 6}, queryIDs(t, col.QueryBuilder().Where("Baz", ">=", base.Add(4*time.Hour))))
+	require.Equal(t, []uint64{6}, queryIDs(t, col.QueryBuilder().
+		Where("Bar", "=", 1).
+		And(col.QueryBuilder().Where("Foo", "=", "f"))))
+
+	_, err = col.Insert(&snapshotTestData{Foo: "f"})
+	var violation *ErrUniqueViolation
+	require.ErrorAs(t, err, &violation)
+	require.Equal(t, uint64(6), violation.ConflictingID)
+
+	// changing a document covered by the snapshot removes it
+	require.NoError(t, col.Update(6, &snapshotTestData{Foo: "f", Bar: 0}))
+	require.FileExists(t, snapshotPath)
+	require.NoError(t, col.Update(2, &snapshotTestData{Foo: "b", Bar: 0}))
+	require.NoFileExists(t, snapshotPath)
+
+	require.NoError(t, col.Close())
+	require.FileExists(t, snapshotPath)
+
+	t.Run("corrupt snapshot is rebuilt", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(doc1Path, doc1, 0666))
+
+		data, err := os.ReadFile(snapshotPath)
+		require.NoError(t, err)
+		data[len(data)-2] ^= 0xff
+		require.NoError(t, os.WriteFile(snapshotPath, data, 0666))
+
+		col := open(t, indexOpts...)
+		require.Equal(t, []uint64{4}, queryIDs(t, col.QueryBuilder().Where("Bar", "=", 1)))
+		require.NoError(t, col.Close())
+	})
+
+	t.Run("snapshot of different indexes is rebuilt", func(t *testing.T) {
+		col := open(t, WithUnorderedIndex[snapshotTestData]("Bar"))
+		require.Equal(t, []uint64{4}, queryIDs(t, col.QueryBuilder().Where("Bar", "=", 1)))
+		require.NoError(t, col.Close())
+
+		col = open(t, indexOpts...)
+		require.Equal(t, []uint64{1, 2, 3, 5, 6}, queryIDs(t, col.QueryBuilder().Where("Bar", "=", 0)))
+		require.NoError(t, col.Close())
+	})
+}
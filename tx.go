@@ -0,0 +1,677 @@
+package goflatdb
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+const txLogFileName = "tx.log"
+
+// Tx stages inserts, updates and deletes across the collections of a FlatDB and applies them atomically on Commit.
+//
+// Isolation is read committed:
+//   - Staged writes are invisible until Commit, both to other readers and to reads made while the transaction
+//     is open, as all reads go to the collections.
+//   - Commit holds the write locks of every collection it touches, so GetByID and index lookups observe either
+//     none or all of its writes to a collection. Queries reading documents lazily may observe a commit that
+//     happens while they iterate, and reads spanning several collections are not taken from a single snapshot.
+//   - Writes don't conflict: a document updated by someone else after it was read is overwritten by Commit.
+//
+// Ids of staged inserts are allocated when they are staged, so a rolled back insert leaves a gap in the ids.
+// If the process crashes during Commit, the transaction is completed when the FlatDB is opened again.
+type Tx struct {
+	db *FlatDB
+
+	mu   sync.Mutex
+	ops  []txOp
+	done bool
+}
+
+type txOp struct {
+	col    txCollection
+	record walRecord
+}
+
+// txCollection is the part of a FlatDBCollection a transaction works with, independent of its document type.
+type txCollection interface {
+	collectionName() string
+	documentFileName(id uint64) string
+	flatDB() *FlatDB
+	lockTx()
+	unlockTx()
+	// prepareTx runs the before-hooks of records, validates them and moves the documents they write
+	// between index keys. Caller must hold the collection lock.
+	prepareTx(ctx context.Context, records []walRecord) (txChanges, error)
+	// applyTxWrite writes or removes the file of a document. Caller must hold the collection lock.
+	applyTxWrite(w txWrite) error
+	// recordTxWrite records an applied write in the change log. Caller must hold the collection lock.
+	recordTxWrite(w txWrite)
+}
+
+// txChanges are the writes a transaction makes to a collection, with a func restoring the indexes
+// to their state before prepareTx and a func running the after-hooks of the writes.
+type txChanges struct {
+	col           txCollection
+	writes        []txWrite
+	revertIndexes func()
+	runAfterHooks func(ctx context.Context)
+}
+
+// txWrite sets the file of document ID to Data, or removes it if Data is nil. Old is the previous content
+// of the file, nil if there was no such document.
+type txWrite struct {
+	ID   uint64
+	Data []byte
+	Old  []byte
+	Seq  uint64 // sequence number of the change event of the write, 0 if it has none
+}
+
+// txLogRecord is a write of a committed transaction, stored in the transaction log until it has been applied.
+type txLogRecord struct {
+	Collection string `json:"collection"`
+	// FileName is the name of the document file, which depends on the codec of the collection.
+	// Logs written before codecs were introduced leave it empty, their documents are stored as JSON.
+	FileName string `json:"fileName,omitempty"`
+	walRecord
+}
+
+// Begin starts a transaction.
+func (db *FlatDB) Begin() *Tx {
+	return &Tx{db: db}
+}
+
+// InsertTx stages the insert of data in tx. The returned id is reserved for the document even if tx is rolled back.
+func (c *FlatDBCollection[T]) InsertTx(tx *Tx, data *T) (InsertResult, error) {
+	if err := tx.check(c); err != nil {
+		return InsertResult{}, errInsertingIntoCollection(c.name, err)
+	}
+
+	id, err := c.GetNextID(c.idFile)
+	if err != nil {
+		return InsertResult{}, errInsertingIntoCollection(c.name, err)
+	}
+
+	bytes, err := c.encodeDocument(&FlatDBModel[T]{Data: *data, ID: id, Version: 1})
+	if err != nil {
+		return InsertResult{}, errInsertingIntoCollection(c.name, err)
+	}
+
+	if err := tx.stage(c, walRecord{Op: walOpInsert, ID: id, Data: bytes}); err != nil {
+		return InsertResult{}, errInsertingIntoCollection(c.name, err)
+	}
+
+	return InsertResult{ID: id}, nil
+}
+
+// UpdateTx stages the update of document id in tx. Commit fails with DocumentNotFound if there is no such document.
+// The version and the timestamps of the document are set on commit.
+func (c *FlatDBCollection[T]) UpdateTx(tx *Tx, id uint64, data *T) error {
+	if err := tx.check(c); err != nil {
+		return errUpdatingDocument(c.name, id, err)
+	}
+
+	bytes, err := c.encodeDocument(&FlatDBModel[T]{Data: *data, ID: id})
+	if err != nil {
+		return errUpdatingDocument(c.name, id, err)
+	}
+
+	if err := tx.stage(c, walRecord{Op: walOpUpdate, ID: id, Data: bytes}); err != nil {
+		return errUpdatingDocument(c.name, id, err)
+	}
+
+	return nil
+}
+
+// DeleteTx stages the delete of document id in tx. Commit fails with DocumentNotFound if there is no such document.
+func (c *FlatDBCollection[T]) DeleteTx(tx *Tx, id uint64) error {
+	if err := tx.stage(c, walRecord{Op: walOpDelete, ID: id}); err != nil {
+		return errDeletingDocument(c.name, id, err)
+	}
+
+	return nil
+}
+
+func (tx *Tx) check(c txCollection) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	return tx.checkLocked(c)
+}
+
+// checkLocked is check for callers holding tx.mu.
+func (tx *Tx) checkLocked(c txCollection) error {
+	if tx.done {
+		return ErrTxDone
+	}
+
+	if c.flatDB() != tx.db {
+		return fmt.Errorf("collection %s doesn't belong to the transaction's db", c.collectionName())
+	}
+
+	return nil
+}
+
+func (tx *Tx) stage(c txCollection, record walRecord) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if err := tx.checkLocked(c); err != nil {
+		return err
+	}
+
+	tx.ops = append(tx.ops, txOp{col: c, record: record})
+
+	return nil
+}
+
+// Rollback discards the staged writes of tx.
+func (tx *Tx) Rollback() error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if tx.done {
+		return ErrTxDone
+	}
+
+	tx.done = true
+	tx.ops = nil
+
+	return nil
+}
+
+// Commit applies the staged writes of tx: either all of them are applied, or none is.
+// Writes are validated as a whole, for example an update of a document deleted earlier in tx fails,
+// and unique indexes are checked against the state after all writes.
+//
+// Before-hooks of the collections run for every staged write during Commit and abort it on error.
+// After-hooks run for every document the transaction changed once all writes are applied.
+func (tx *Tx) Commit() error {
+	return tx.CommitContext(context.Background())
+}
+
+func (tx *Tx) CommitContext(ctx context.Context) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+
+	if err := ctx.Err(); err != nil {
+		return errCommittingTx(err)
+	}
+
+	changes, err := tx.apply(ctx)
+	if err != nil {
+		return errCommittingTx(err)
+	}
+
+	for _, ch := range changes {
+		ch.runAfterHooks(ctx)
+	}
+
+	return nil
+}
+
+// apply validates and applies the staged writes and returns the changes made to each collection.
+func (tx *Tx) apply(ctx context.Context) ([]txChanges, error) {
+	if len(tx.ops) == 0 {
+		return nil, nil
+	}
+
+	tx.db.commitMu.Lock()
+	defer tx.db.commitMu.Unlock()
+
+	// collections are locked in name order, so concurrent commits can't deadlock
+	cols := []txCollection{}
+	records := map[txCollection][]walRecord{}
+	for _, op := range tx.ops {
+		if _, ok := records[op.col]; !ok {
+			cols = append(cols, op.col)
+		}
+		records[op.col] = append(records[op.col], op.record)
+	}
+	sort.SliceStable(cols, func(i, j int) bool {
+		return cols[i].collectionName() < cols[j].collectionName()
+	})
+
+	for _, col := range cols {
+		col.lockTx()
+		defer col.unlockTx()
+	}
+
+	changes := make([]txChanges, 0, len(cols))
+	revertIndexes := func() {
+		for i := len(changes) - 1; i >= 0; i-- {
+			changes[i].revertIndexes()
+		}
+	}
+
+	logRecords := []txLogRecord{}
+	for _, col := range cols {
+		ch, err := col.prepareTx(ctx, records[col])
+		if err != nil {
+			revertIndexes()
+			return nil, err
+		}
+		changes = append(changes, ch)
+
+		for _, w := range ch.writes {
+			logRecords = append(logRecords, txLogRecord{
+				Collection: col.collectionName(),
+				FileName:   col.documentFileName(w.ID),
+				walRecord:  w.logRecord(),
+			})
+		}
+	}
+
+	if len(logRecords) == 0 {
+		return nil, nil
+	}
+
+	if err := writeTxLog(tx.db.dir, logRecords); err != nil {
+		revertIndexes()
+		return nil, err
+	}
+
+	applied := []txWrite{}
+	appliedCols := []txCollection{}
+	for _, ch := range changes {
+		for _, w := range ch.writes {
+			if err := ch.col.applyTxWrite(w); err != nil {
+				tx.undo(applied, appliedCols)
+				revertIndexes()
+				return nil, err
+			}
+
+			applied = append(applied, w)
+			appliedCols = append(appliedCols, ch.col)
+		}
+	}
+
+	// the events are recorded before the log is removed, so a crash in between can't lose them
+	for _, ch := range changes {
+		for _, w := range ch.writes {
+			ch.col.recordTxWrite(w)
+		}
+	}
+
+	if err := removeTxLog(tx.db.dir); err != nil {
+		// every write is applied, replaying them again on the next start is harmless
+		tx.db.logger.Error("error removing transaction log", zap.Error(err))
+	}
+
+	return changes, nil
+}
+
+// undo restores the files changed by the applied writes of a failed commit. If that fails too, the transaction
+// log is kept, so the commit is completed on the next start instead.
+func (tx *Tx) undo(applied []txWrite, cols []txCollection) {
+	for i := len(applied) - 1; i >= 0; i-- {
+		w := applied[i]
+		if err := cols[i].applyTxWrite(txWrite{ID: w.ID, Data: w.Old, Old: w.Data}); err != nil {
+			tx.db.logger.Error("error undoing transaction, it will be completed on the next start", zap.Error(err))
+			return
+		}
+	}
+
+	if err := removeTxLog(tx.db.dir); err != nil {
+		tx.db.logger.Error("error removing transaction log", zap.Error(err))
+	}
+}
+
+func (w txWrite) logRecord() walRecord {
+	switch {
+	case w.Data == nil && w.Seq != 0:
+		return walRecord{Op: walOpDelete, ID: w.ID, Seq: w.Seq, Old: w.Old}
+	case w.Data == nil:
+		return walRecord{Op: walOpDelete, ID: w.ID}
+	case w.Old == nil:
+		return walRecord{Op: walOpInsert, ID: w.ID, Data: w.Data, Seq: w.Seq}
+	default:
+		return walRecord{Op: walOpUpdate, ID: w.ID, Data: w.Data, Seq: w.Seq}
+	}
+}
+
+func (c *FlatDBCollection[T]) collectionName() string {
+	return c.name
+}
+
+func (c *FlatDBCollection[T]) flatDB() *FlatDB {
+	return c.db
+}
+
+func (c *FlatDBCollection[T]) lockTx() {
+	c.mu.Lock()
+}
+
+func (c *FlatDBCollection[T]) unlockTx() {
+	c.mu.Unlock()
+}
+
+func (c *FlatDBCollection[T]) prepareTx(ctx context.Context, records []walRecord) (txChanges, error) {
+	type state struct {
+		old    []byte
+		oldDoc FlatDBModel[T]
+		data   []byte // nil while the document doesn't exist
+		doc    FlatDBModel[T]
+	}
+
+	ids := []uint64{}
+	states := map[uint64]*state{}
+	for _, record := range records {
+		s, ok := states[record.ID]
+		if !ok {
+			s = &state{}
+			if record.Op != walOpInsert {
+				old, err := os.ReadFile(documentFilePath(c.dir.Name(), c.documentFileName(record.ID)))
+				if err != nil && !errors.Is(err, os.ErrNotExist) {
+					return txChanges{}, err
+				}
+
+				if old != nil {
+					if old, err = decompressDocument(old); err != nil {
+						return txChanges{}, errorReadingDocument(c.documentFileName(record.ID), err)
+					}
+					if s.oldDoc, err = c.decodeDocument(old); err != nil {
+						return txChanges{}, errorReadingDocument(c.documentFileName(record.ID), err)
+					}
+				}
+				s.old, s.data, s.doc = old, old, s.oldDoc
+			}
+
+			ids = append(ids, record.ID)
+			states[record.ID] = s
+		}
+
+		if record.Op != walOpInsert && s.data == nil {
+			return txChanges{}, fmt.Errorf("document %d in collection %s: %w", record.ID, c.name, DocumentNotFound)
+		}
+
+		if record.Op == walOpDelete {
+			if err := c.runBeforeDelete(ctx, s.doc); err != nil {
+				return txChanges{}, err
+			}
+
+			s.data, s.doc = nil, FlatDBModel[T]{}
+			continue
+		}
+
+		doc, err := c.decodeDocument(record.Data)
+		if err != nil {
+			return txChanges{}, err
+		}
+
+		if record.Op == walOpUpdate {
+			if err := c.runBeforeUpdate(ctx, s.doc, &doc.Data); err != nil {
+				return txChanges{}, err
+			}
+
+			doc.Version = s.doc.Version + 1
+			doc.touch(&s.doc)
+		} else {
+			if err := c.runBeforeInsert(ctx, &doc.Data); err != nil {
+				return txChanges{}, err
+			}
+
+			doc.touch(nil)
+		}
+
+		if err := c.validate(&doc.Data); err != nil {
+			return txChanges{}, err
+		}
+
+		data, err := c.encodeDocument(&doc)
+		if err != nil {
+			return txChanges{}, err
+		}
+		s.data, s.doc = data, doc
+	}
+
+	changes := txChanges{col: c}
+	for _, id := range ids {
+		s := states[id]
+		if s.old == nil && s.data == nil {
+			continue
+		}
+
+		changes.writes = append(changes.writes, txWrite{ID: id, Data: s.data, Old: s.old})
+	}
+
+	// the transaction log carries the change events, so recoverTxLog records them if the commit is interrupted
+	if c.changeLog != nil {
+		for i := range changes.writes {
+			changes.writes[i].Seq = c.changeLog.nextSeq() + uint64(i)
+		}
+	}
+
+	// indexes are moved to the state after the transaction, which makes unique violations visible
+	for _, w := range changes.writes {
+		if s := states[w.ID]; s.old != nil {
+			c.unindexDocument(s.oldDoc)
+		}
+	}
+	for _, w := range changes.writes {
+		if s := states[w.ID]; s.data != nil {
+			c.indexDocument(s.doc)
+		}
+	}
+
+	changes.revertIndexes = func() {
+		for _, w := range changes.writes {
+			if s := states[w.ID]; s.data != nil {
+				c.unindexDocument(s.doc)
+			}
+		}
+		for _, w := range changes.writes {
+			if s := states[w.ID]; s.old != nil {
+				c.indexDocument(s.oldDoc)
+			}
+		}
+	}
+
+	changes.runAfterHooks = func(ctx context.Context) {
+		for _, w := range changes.writes {
+			switch s := states[w.ID]; {
+			case s.data == nil:
+				c.runAfterDelete(ctx, s.oldDoc)
+			case s.old == nil:
+				c.runAfterInsert(ctx, s.doc)
+			default:
+				c.runAfterUpdate(ctx, s.oldDoc, s.doc)
+			}
+		}
+	}
+
+	for _, w := range changes.writes {
+		if s := states[w.ID]; s.data != nil {
+			if err := c.checkUniqueIndexes(s.doc); err != nil {
+				changes.revertIndexes()
+				return txChanges{}, fmt.Errorf("document %d in collection %s: %w", w.ID, c.name, err)
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+func (c *FlatDBCollection[T]) applyTxWrite(w txWrite) error {
+	if w.Old != nil {
+		if err := c.invalidateIndexSnapshot(w.ID); err != nil {
+			return err
+		}
+	}
+
+	if w.Data == nil {
+		return c.removeDocument(w.ID)
+	}
+
+	return c.writeDocument(w.Data, w.ID)
+}
+
+func (c *FlatDBCollection[T]) recordTxWrite(w txWrite) {
+	c.recordChange(w.logRecord())
+}
+
+// writeTxLog durably stores the writes of a transaction before they are applied.
+func writeTxLog(dir string, records []txLogRecord) error {
+	payload, err := json.Marshal(records)
+	if err != nil {
+		return errorWritingTxLog(err)
+	}
+
+	dirFile, err := os.Open(dir)
+	if err != nil {
+		return errorWritingTxLog(err)
+	}
+	defer func() {
+		_ = dirFile.Close()
+	}()
+
+	if err := writeFileAtomic(dirFile, txLogFileName, encodeLogEntry(payload), DurabilityFileAndDirSync); err != nil {
+		return errorWritingTxLog(err)
+	}
+
+	return nil
+}
+
+func removeTxLog(dir string) error {
+	if err := os.Remove(filepath.Join(dir, txLogFileName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// recoverTxLog applies the writes of a transaction whose commit was interrupted by a crash.
+// Collections are not open yet, so their files are changed directly and their index snapshots are removed.
+func recoverTxLog(dir string, logger *zap.Logger) error {
+	if err := removeTempFiles(dir); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errorRecoveringTxLog(err)
+	}
+
+	entry, err := os.ReadFile(filepath.Join(dir, txLogFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return errorRecoveringTxLog(err)
+	}
+
+	records := []txLogRecord{}
+	payload, ok := decodeLogEntry(entry)
+	if ok {
+		ok = json.Unmarshal(payload, &records) == nil
+	}
+	if !ok {
+		// the log is renamed into place once complete, a corrupt one was never committed
+		logger.Error("discarding corrupt transaction log")
+		return removeTxLog(dir)
+	}
+
+	logger.Info("completing interrupted transaction", zap.Int("writes", len(records)))
+
+	for _, record := range records {
+		if record.FileName == "" {
+			record.FileName = documentFileName(record.ID, JSONCodec)
+		}
+
+		if err := recoverTxLogRecord(filepath.Join(dir, record.Collection), record.FileName, record.walRecord); err != nil {
+			return errorRecoveringTxLog(err)
+		}
+	}
+
+	if err := removeTxLog(dir); err != nil {
+		return errorRecoveringTxLog(err)
+	}
+
+	return nil
+}
+
+func recoverTxLogRecord(colDir string, fileName string, record walRecord) error {
+	dirFile, err := os.Open(colDir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dirFile.Close()
+	}()
+
+	if err := os.Remove(filepath.Join(colDir, indexSnapshotFileName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if record.Op == walOpDelete {
+		err := os.Remove(documentFilePath(colDir, fileName))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := dirFile.Sync(); err != nil {
+			return err
+		}
+	} else if err := writeFileAtomic(dirFile, fileName, record.Data, DurabilityFileAndDirSync); err != nil {
+		return err
+	}
+
+	if err := recoverChange(colDir, record); err != nil {
+		return err
+	}
+
+	idFile, err := os.OpenFile(filepath.Join(colDir, "id.txt"), os.O_RDWR, 0777)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = idFile.Close()
+	}()
+
+	curID, err := readID(idFile)
+	if err != nil {
+		return err
+	}
+
+	if record.ID > curID {
+		if err := writeID(idFile, record.ID); err != nil {
+			return err
+		}
+	}
+
+	return idFile.Sync()
+}
+
+// decodeLogEntry returns the payload of an entry encoded by encodeLogEntry. ok is false if the entry is torn or corrupt.
+func decodeLogEntry(entry []byte) (payload []byte, ok bool) {
+	if len(entry) < 8 {
+		return nil, false
+	}
+
+	payload = entry[8:]
+	if uint32(len(payload)) != binary.BigEndian.Uint32(entry[0:4]) || crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(entry[4:8]) {
+		return nil, false
+	}
+
+	return payload, true
+}
+
+func errCommittingTx(err error) error {
+	return fmt.Errorf("error committing transaction: %w", err)
+}
+
+func errorWritingTxLog(err error) error {
+	return fmt.Errorf("error writing transaction log: %w", err)
+}
+
+func errorRecoveringTxLog(err error) error {
+	return fmt.Errorf("error recovering transaction log: %w", err)
+}
@@ -0,0 +1,209 @@
+package goflatdb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type txTestOrder struct {
+	Item     string `json:"item"`
+	Quantity int    `json:"quantity"`
+}
+
+type txTestInventory struct {
+	Item  string `json:"item"`
+	Stock int    `json:"stock"`
+}
+
+func TestTx(t *testing.T) {
+	setup := func(t *testing.T) (*FlatDB, *FlatDBCollection[txTestOrder], *FlatDBCollection[txTestInventory]) {
+		dir := t.TempDir()
+
+		logger, err := zap.NewDevelopment()
+		require.NoError(t, err)
+
+		db, err := NewFlatDB(dir, logger)
+		require.NoError(t, err)
+
+		orders, err := NewFlatDBCollection[txTestOrder](db, "orders", logger, WithUnorderedIndex[txTestOrder]("item"))
+		require.NoError(t, err)
+
+		inventory, err := NewFlatDBCollection[txTestInventory](db, "inventory", logger, WithUniqueIndex[txTestInventory]("item"))
+		require.NoError(t, err)
+
+		_, err = inventory.Insert(&txTestInventory{Item: "apple", Stock: 10})
+		require.NoError(t, err)
+		_, err = inventory.Insert(&txTestInventory{Item: "pear", Stock: 5})
+		require.NoError(t, err)
+
+		return db, orders, inventory
+	}
+
+	t.Run("commit applies writes to every collection", func(t *testing.T) {
+		db, orders, inventory := setup(t)
+
+		tx := db.Begin()
+		res, err := orders.InsertTx(tx, &txTestOrder{Item: "apple", Quantity: 3})
+		require.NoError(t, err)
+		require.NoError(t, inventory.UpdateTx(tx, 1, &txTestInventory{Item: "apple", Stock: 7}))
+		require.NoError(t, inventory.DeleteTx(tx, 2))
+
+		// staged writes are invisible until commit
+		_, err = orders.GetByID(res.ID)
+		require.ErrorIs(t, err, DocumentNotFound)
+		doc, err := inventory.GetByID(1)
+		require.NoError(t, err)
+		require.Equal(t, 10, doc.Data.Stock)
+		_, err = inventory.GetByID(2)
+		require.NoError(t, err)
+
+		require.NoError(t, tx.Commit())
+
+		order, err := orders.GetByID(res.ID)
+		require.NoError(t, err)
+		require.Equal(t, txTestOrder{Item: "apple", Quantity: 3}, order.Data)
+		doc, err = inventory.GetByID(1)
+		require.NoError(t, err)
+		require.Equal(t, 7, doc.Data.Stock)
+		_, err = inventory.GetByID(2)
+		require.ErrorIs(t, err, DocumentNotFound)
+
+		found, err := orders.QueryBuilder().Where("item", "=", "apple").Execute()
+		require.NoError(t, err)
+		require.Len(t, found, 1)
+		stock, err := inventory.QueryBuilder().Where("item", "=", "pear").Execute()
+		require.NoError(t, err)
+		require.Len(t, stock, 0)
+
+		_, err = os.Stat(filepath.Join(db.dir, txLogFileName))
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		require.ErrorIs(t, tx.Commit(), ErrTxDone)
+		require.ErrorIs(t, tx.Rollback(), ErrTxDone)
+	})
+
+	t.Run("rollback discards writes", func(t *testing.T) {
+		db, orders, inventory := setup(t)
+
+		tx := db.Begin()
+		res, err := orders.InsertTx(tx, &txTestOrder{Item: "apple", Quantity: 3})
+		require.NoError(t, err)
+		require.NoError(t, inventory.DeleteTx(tx, 1))
+		require.NoError(t, tx.Rollback())
+
+		_, err = orders.GetByID(res.ID)
+		require.ErrorIs(t, err, DocumentNotFound)
+		_, err = inventory.GetByID(1)
+		require.NoError(t, err)
+
+		_, err = orders.InsertTx(tx, &txTestOrder{Item: "pear"})
+		require.ErrorIs(t, err, ErrTxDone)
+
+		// the id of the rolled back insert is not reused
+		next, err := orders.Insert(&txTestOrder{Item: "pear"})
+		require.NoError(t, err)
+		require.Equal(t, res.ID+1, next.ID)
+	})
+
+	t.Run("failed commit applies nothing", func(t *testing.T) {
+		db, orders, inventory := setup(t)
+
+		tx := db.Begin()
+		_, err := orders.InsertTx(tx, &txTestOrder{Item: "apple", Quantity: 3})
+		require.NoError(t, err)
+		require.NoError(t, inventory.UpdateTx(tx, 1, &txTestInventory{Item: "apple", Stock: 7}))
+		_, err = inventory.InsertTx(tx, &txTestInventory{Item: "pear", Stock: 1})
+		require.NoError(t, err)
+
+		var violation *ErrUniqueViolation
+		require.ErrorAs(t, tx.Commit(), &violation)
+		require.Equal(t, uint64(2), violation.ConflictingID)
+
+		found, err := orders.QueryBuilder().Select().Execute()
+		require.NoError(t, err)
+		require.Len(t, found, 0)
+		doc, err := inventory.GetByID(1)
+		require.NoError(t, err)
+		require.Equal(t, 10, doc.Data.Stock)
+		stock, err := inventory.QueryBuilder().Where("item", "=", "pear").Execute()
+		require.NoError(t, err)
+		require.Len(t, stock, 1)
+
+		tx = db.Begin()
+		require.NoError(t, inventory.DeleteTx(tx, 2))
+		require.NoError(t, inventory.UpdateTx(tx, 2, &txTestInventory{Item: "pear"}))
+		require.ErrorIs(t, tx.Commit(), DocumentNotFound)
+
+		_, err = inventory.GetByID(2)
+		require.NoError(t, err)
+	})
+
+	t.Run("writes within a transaction see each other", func(t *testing.T) {
+		db, _, inventory := setup(t)
+
+		tx := db.Begin()
+		require.NoError(t, inventory.DeleteTx(tx, 2))
+		res, err := inventory.InsertTx(tx, &txTestInventory{Item: "pear", Stock: 1})
+		require.NoError(t, err)
+		require.NoError(t, inventory.UpdateTx(tx, res.ID, &txTestInventory{Item: "pear", Stock: 2}))
+		require.NoError(t, tx.Commit())
+
+		found, err := inventory.QueryBuilder().Where("item", "=", "pear").Execute()
+		require.NoError(t, err)
+		require.Len(t, found, 1)
+		require.Equal(t, res.ID, found[0].ID)
+		require.Equal(t, uint64(2), found[0].Version)
+		require.Equal(t, txTestInventory{Item: "pear", Stock: 2}, found[0].Data)
+	})
+
+	t.Run("collections of another db are rejected", func(t *testing.T) {
+		_, orders, _ := setup(t)
+		other, _, _ := setup(t)
+
+		_, err := orders.InsertTx(other.Begin(), &txTestOrder{Item: "apple"})
+		require.Error(t, err)
+	})
+
+	t.Run("interrupted commit is completed on start", func(t *testing.T) {
+		db, orders, inventory := setup(t)
+
+		res, err := orders.GetNextID(orders.idFile)
+		require.NoError(t, err)
+
+		require.NoError(t, writeTxLog(db.dir, []txLogRecord{
+			{Collection: "orders", walRecord: walRecord{Op: walOpInsert, ID: res, Data: []byte(`{"data":{"item":"apple","quantity":3},"ID":1}`)}},
+			{Collection: "inventory", walRecord: walRecord{Op: walOpUpdate, ID: 1, Data: []byte(`{"data":{"item":"apple","stock":7},"ID":1}`)}},
+			{Collection: "inventory", walRecord: walRecord{Op: walOpDelete, ID: 2}},
+		}))
+		require.NoError(t, orders.Close())
+		require.NoError(t, inventory.Close())
+
+		logger, err := zap.NewDevelopment()
+		require.NoError(t, err)
+
+		db, err = NewFlatDB(db.dir, logger)
+		require.NoError(t, err)
+
+		orders, err = NewFlatDBCollection[txTestOrder](db, "orders", logger, WithUnorderedIndex[txTestOrder]("item"))
+		require.NoError(t, err)
+		inventory, err = NewFlatDBCollection[txTestInventory](db, "inventory", logger, WithUniqueIndex[txTestInventory]("item"))
+		require.NoError(t, err)
+
+		found, err := orders.QueryBuilder().Where("item", "=", "apple").Execute()
+		require.NoError(t, err)
+		require.Len(t, found, 1)
+		doc, err := inventory.GetByID(1)
+		require.NoError(t, err)
+		require.Equal(t, 7, doc.Data.Stock)
+		stock, err := inventory.QueryBuilder().Where("item", "=", "pear").Execute()
+		require.NoError(t, err)
+		require.Len(t, stock, 0)
+
+		_, err = os.Stat(filepath.Join(db.dir, txLogFileName))
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
@@ -0,0 +1,349 @@
+package goflatdb
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validator is implemented by document types that check themselves on write, see WithValidation.
+// Validate may return a *ValidationError to report several fields.
+type Validator interface {
+	Validate() error
+}
+
+// ValidationError is returned when a write is rejected by validation. It lists every failing rule.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		msgs[i] = field.Error()
+	}
+
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the errors of the failing fields, so errors returned by Validate can be matched with errors.Is.
+func (e *ValidationError) Unwrap() []error {
+	errs := make([]error, len(e.Fields))
+	for i, field := range e.Fields {
+		errs[i] = field.Err
+	}
+
+	return errs
+}
+
+// FieldError is a validation rule a field failed.
+type FieldError struct {
+	Field string // path of the field, empty when Validate rejected the document as a whole
+	Rule  string // required, min, max, enum or validate
+	Err   error
+}
+
+func (e FieldError) Error() string {
+	if e.Field == "" {
+		return e.Err.Error()
+	}
+
+	return e.Field + " " + e.Err.Error()
+}
+
+// structRules are the validation rules set by the flatdb tags of a struct type and of the structs nested in it.
+type structRules struct {
+	fields []fieldRules
+}
+
+type fieldRules struct {
+	index []int
+	name  string
+
+	required bool
+	min, max *float64
+	enum     []string
+
+	nested *structRules // rules of the struct, or the elements of the slice, the field holds
+}
+
+func isValidationRule(elem string) bool {
+	rule, _, _ := strings.Cut(elem, "=")
+	switch rule {
+	case "required", "min", "max", "enum":
+		return true
+	default:
+		return false
+	}
+}
+
+// compileRules parses the rules of t, which is nil if t holds no rules.
+func compileRules(t reflect.Type, seen map[reflect.Type]*structRules) (*structRules, error) {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct {
+		return nil, nil
+	}
+
+	if rules, ok := seen[t]; ok {
+		return rules, nil
+	}
+
+	rules := &structRules{}
+	// recursive types refer to the rules being compiled
+	seen[t] = rules
+
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() || field.Anonymous {
+			continue
+		}
+
+		name, elems := flatdbTag(field.Tag.Get("flatdb"))
+		if name == "" {
+			name = tagName(field.Tag.Get("json"))
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		f := fieldRules{index: field.Index, name: name}
+		for _, elem := range elems {
+			if err := f.parseRule(field, elem); err != nil {
+				return nil, err
+			}
+		}
+
+		nested, err := compileRules(field.Type, seen)
+		if err != nil {
+			return nil, err
+		}
+		f.nested = nested
+
+		if f.required || f.min != nil || f.max != nil || f.enum != nil || f.nested != nil {
+			rules.fields = append(rules.fields, f)
+		}
+	}
+
+	if len(rules.fields) == 0 {
+		seen[t] = nil
+		return nil, nil
+	}
+
+	return rules, nil
+}
+
+func (f *fieldRules) parseRule(field reflect.StructField, elem string) error {
+	rule, arg, _ := strings.Cut(elem, "=")
+
+	t := field.Type
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch rule {
+	case "required":
+		f.required = true
+	case "min", "max":
+		if _, ok := measure(reflect.Zero(t)); !ok {
+			return errorParsingRule(field.Name, elem, fmt.Errorf("%s has no length or numeric value", t))
+		}
+
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return errorParsingRule(field.Name, elem, err)
+		}
+
+		if rule == "min" {
+			f.min = &limit
+		} else {
+			f.max = &limit
+		}
+	case "enum":
+		switch t.Kind() {
+		case reflect.String, reflect.Bool,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+		default:
+			return errorParsingRule(field.Name, elem, fmt.Errorf("values of %s can't be listed", t))
+		}
+
+		if arg == "" {
+			return errorParsingRule(field.Name, elem, errors.New("no values"))
+		}
+
+		f.enum = strings.Split(arg, "|")
+	case "":
+		// empty elements, as in `flatdb:"name,"`, set no rule
+	default:
+		return errorParsingRule(field.Name, elem, errors.New("unknown rule"))
+	}
+
+	return nil
+}
+
+// measure returns the number v is compared with by min and max: the value of numbers
+// and the length of strings, slices and maps.
+func measure(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), true
+	default:
+		return 0, false
+	}
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	default:
+		return v.IsZero()
+	}
+}
+
+// validate appends the rules v fails to errs, naming fields by their path below path.
+func (r *structRules) validate(v reflect.Value, path fieldPath, errs []FieldError) []FieldError {
+	for _, f := range r.fields {
+		fieldPath := append(path[:len(path):len(path)], pathSegment{kind: pathSegmentField, name: f.name})
+		field, err := v.FieldByIndexErr(f.index)
+		if err != nil {
+			// a promoted field of a nil embedded struct
+			continue
+		}
+
+		errs = f.validate(field, fieldPath, errs)
+	}
+
+	return errs
+}
+
+func (f *fieldRules) validate(v reflect.Value, path fieldPath, errs []FieldError) []FieldError {
+	fail := func(rule string, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: path.String(), Rule: rule, Err: fmt.Errorf(format, args...)})
+	}
+
+	if f.required && isEmpty(v) {
+		fail("required", "is required")
+		return errs
+	}
+
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return errs
+		}
+		v = v.Elem()
+	}
+
+	if f.min != nil || f.max != nil {
+		n, _ := measure(v)
+
+		what := "must be"
+		if !v.CanInt() && !v.CanUint() && !v.CanFloat() {
+			what = "must have a length of"
+		}
+
+		if f.min != nil && n < *f.min {
+			fail("min", "%s at least %v", what, *f.min)
+		}
+		if f.max != nil && n > *f.max {
+			fail("max", "%s at most %v", what, *f.max)
+		}
+	}
+
+	// fields that aren't required may be left empty instead of set to a listed value
+	if f.enum != nil && !v.IsZero() && !containsString(f.enum, fmt.Sprint(v.Interface())) {
+		fail("enum", "must be one of %s", strings.Join(f.enum, ", "))
+	}
+
+	if f.nested != nil {
+		switch v.Kind() {
+		case reflect.Struct:
+			errs = f.nested.validate(v, path, errs)
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < v.Len(); i++ {
+				elem := v.Index(i)
+				for elem.Kind() == reflect.Pointer && !elem.IsNil() {
+					elem = elem.Elem()
+				}
+				if elem.Kind() != reflect.Struct {
+					continue
+				}
+
+				elemPath := append(path[:len(path):len(path)], pathSegment{kind: pathSegmentIndex, index: i})
+				errs = f.nested.validate(elem, elemPath, errs)
+			}
+		}
+	}
+
+	return errs
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validate checks data against the rules of its flatdb tags and its Validate method, if the collection
+// was created with WithValidation. Caller must hold c.mu.
+func (c *FlatDBCollection[T]) validate(data *T) error {
+	if !c.validationEnabled {
+		return nil
+	}
+
+	var errs []FieldError
+
+	if c.rules != nil {
+		v := reflect.ValueOf(data).Elem()
+		for v.Kind() == reflect.Pointer && !v.IsNil() {
+			v = v.Elem()
+		}
+
+		if v.Kind() == reflect.Struct {
+			errs = c.rules.validate(v, nil, errs)
+		}
+	}
+
+	validator, ok := interface{}(data).(Validator)
+	if !ok {
+		validator, ok = interface{}(*data).(Validator)
+	}
+	if ok {
+		if err := validator.Validate(); err != nil {
+			var validationErr *ValidationError
+			if errors.As(err, &validationErr) {
+				errs = append(errs, validationErr.Fields...)
+			} else {
+				errs = append(errs, FieldError{Rule: "validate", Err: err})
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Fields: errs}
+	}
+
+	return nil
+}
+
+func errorParsingRule(field string, rule string, err error) error {
+	return fmt.Errorf("error parsing rule %q of field %s: %w", rule, field, err)
+}
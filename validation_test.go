@@ -0,0 +1,132 @@
+package goflatdb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var errReservedName = errors.New("name is reserved")
+
+type validationTestItem struct {
+	SKU      string `json:"sku" flatdb:"required"`
+	Quantity int    `json:"quantity" flatdb:",min=1"`
+}
+
+type validationTestData struct {
+	Name   string               `json:"name" flatdb:"title,required,max=10"`
+	Age    int                  `json:"age" flatdb:"min=0,max=150"`
+	Color  string               `json:"color" flatdb:"enum=red|green"`
+	Tags   []string             `json:"tags" flatdb:"max=2"`
+	Score  *float64             `json:"score" flatdb:"min=0"`
+	Items  []validationTestItem `json:"items"`
+	Secret string               `json:"secret"`
+}
+
+func (d validationTestData) Validate() error {
+	if d.Name == "admin" {
+		return errReservedName
+	}
+
+	return nil
+}
+
+func TestValidation(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	db, err := NewFlatDB(dir, logger)
+	require.NoError(t, err)
+
+	col, err := NewFlatDBCollection[validationTestData](db, "test-collection", logger, WithValidation[validationTestData]())
+	require.NoError(t, err)
+
+	res, err := col.Insert(&validationTestData{Name: "alice", Age: 30, Color: "red", Items: []validationTestItem{{SKU: "a", Quantity: 1}}})
+	require.NoError(t, err)
+
+	// tags holding only rules keep the field names set by json tags
+	found, err := col.QueryBuilder().Where("title", "=", "alice").Execute()
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+
+	score := -1.0
+	invalid := validationTestData{
+		Age:   200,
+		Color: "blue",
+		Tags:  []string{"a", "b", "c"},
+		Score: &score,
+		Items: []validationTestItem{{SKU: "a", Quantity: 1}, {Quantity: 0}},
+	}
+
+	_, err = col.Insert(&invalid)
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr)
+
+	type failure struct{ field, rule string }
+	failures := []failure{}
+	for _, f := range validationErr.Fields {
+		failures = append(failures, failure{f.Field, f.Rule})
+	}
+	require.Equal(t, []failure{
+		{"title", "required"},
+		{"age", "max"},
+		{"color", "enum"},
+		{"tags", "max"},
+		{"score", "min"},
+		{"items[1].sku", "required"},
+		{"items[1].quantity", "min"},
+	}, failures)
+	require.Contains(t, err.Error(), "color must be one of red, green")
+	require.Contains(t, err.Error(), "tags must have a length of at most 2")
+
+	// a rejected insert writes nothing
+	_, err = col.GetByID(res.ID + 1)
+	require.ErrorIs(t, err, DocumentNotFound)
+
+	t.Run("updates are validated", func(t *testing.T) {
+		err := col.Update(res.ID, &validationTestData{Name: "a name that is too long"})
+		require.ErrorAs(t, err, &validationErr)
+		require.Equal(t, "title", validationErr.Fields[0].Field)
+
+		_, err = col.Patch(res.ID, []byte(`{"age": -1}`))
+		require.ErrorAs(t, err, &validationErr)
+		require.Equal(t, "age", validationErr.Fields[0].Field)
+
+		tx := db.Begin()
+		require.NoError(t, col.UpdateTx(tx, res.ID, &validationTestData{Name: "bob", Color: "pink"}))
+		require.ErrorAs(t, tx.Commit(), &validationErr)
+
+		doc, err := col.GetByID(res.ID)
+		require.NoError(t, err)
+		require.Equal(t, "alice", doc.Data.Name)
+	})
+
+	t.Run("validate method", func(t *testing.T) {
+		_, err := col.Insert(&validationTestData{Name: "admin", Age: -1})
+		require.ErrorAs(t, err, &validationErr)
+		require.ErrorIs(t, err, errReservedName)
+		require.Len(t, validationErr.Fields, 2)
+		require.Equal(t, "validate", validationErr.Fields[1].Rule)
+	})
+
+	t.Run("invalid rules", func(t *testing.T) {
+		type badRules struct {
+			Enabled bool `flatdb:"min=1"`
+		}
+
+		_, err := NewFlatDBCollection[badRules](db, "bad-collection", logger, WithValidation[badRules]())
+		require.Error(t, err)
+	})
+
+	t.Run("validation is optional", func(t *testing.T) {
+		plain, err := NewFlatDBCollection[validationTestData](db, "plain-collection", logger)
+		require.NoError(t, err)
+
+		_, err = plain.Insert(&invalid)
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,258 @@
+package goflatdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"go.uber.org/zap"
+)
+
+const walFileName = "wal.log"
+
+type walOp uint8
+
+const (
+	walOpInsert walOp = iota + 1
+	walOpUpdate
+	walOpDelete
+)
+
+// walRecord is the intent to change a single document. Data holds the encoded document for inserts and updates.
+// Seq is the sequence number of the change event of the write, 0 if it has none, and Old the encoded document
+// a delete removes, which its change event carries.
+type walRecord struct {
+	Op   walOp  `json:"op"`
+	ID   uint64 `json:"id"`
+	Data []byte `json:"data,omitempty"`
+	Seq  uint64 `json:"seq,omitempty"`
+	Old  []byte `json:"old,omitempty"`
+}
+
+// writeAheadLog records intents before they are applied to the collection files.
+// Every entry is stored as [payload length][payload crc32][payload], so an entry torn by a crash
+// is detected and rolled back on recovery, while complete entries are replayed.
+type writeAheadLog struct {
+	f          *os.File
+	durability Durability
+}
+
+func openWriteAheadLog(path string, durability Durability) (*writeAheadLog, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, errorOpeningWriteAheadLog(path, err)
+	}
+
+	return &writeAheadLog{
+		f:          f,
+		durability: durability,
+	}, nil
+}
+
+// append durably writes records as a single entry.
+func (w *writeAheadLog) append(records ...walRecord) error {
+	payload, err := json.Marshal(records)
+	if err != nil {
+		return errorAppendingToWriteAheadLog(err)
+	}
+
+	if _, err := w.f.Write(encodeLogEntry(payload)); err != nil {
+		return errorAppendingToWriteAheadLog(err)
+	}
+
+	if w.durability >= DurabilityFileSync {
+		if err := w.f.Sync(); err != nil {
+			return errorAppendingToWriteAheadLog(err)
+		}
+	}
+
+	return nil
+}
+
+// encodeLogEntry frames payload as [payload length][payload crc32][payload].
+func encodeLogEntry(payload []byte) []byte {
+	entry := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(entry[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(entry[4:8], crc32.ChecksumIEEE(payload))
+
+	return append(entry, payload...)
+}
+
+// readEntries returns all complete entries of the log. A torn or corrupt tail is ignored.
+func (w *writeAheadLog) readEntries() ([][]walRecord, error) {
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return nil, errorReadingWriteAheadLog(err)
+	}
+
+	entries := [][]walRecord{}
+	r := bufio.NewReader(w.f)
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return nil, errorReadingWriteAheadLog(err)
+		}
+
+		payload := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+		if _, err := io.ReadFull(r, payload); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return nil, errorReadingWriteAheadLog(err)
+		}
+
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+			break
+		}
+
+		records := []walRecord{}
+		if err := json.Unmarshal(payload, &records); err != nil {
+			break
+		}
+
+		entries = append(entries, records)
+	}
+
+	return entries, nil
+}
+
+// reset discards all entries of the log.
+func (w *writeAheadLog) reset() error {
+	if err := w.f.Truncate(0); err != nil {
+		return fmt.Errorf("error resetting write-ahead log: %w", err)
+	}
+
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error resetting write-ahead log: %w", err)
+	}
+
+	if w.durability >= DurabilityFileSync {
+		if err := w.f.Sync(); err != nil {
+			return fmt.Errorf("error resetting write-ahead log: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (w *writeAheadLog) Close() error {
+	return w.f.Close()
+}
+
+// applyIntent logs record, runs apply, records the change event of record and discards the record once apply
+// has finished. If apply fails the record is discarded as well, so it is never replayed. Caller must hold c.mu.
+func (c *FlatDBCollection[T]) applyIntent(record walRecord, apply func() error) error {
+	if c.wal == nil {
+		if err := apply(); err != nil {
+			return err
+		}
+
+		c.recordChange(record)
+		return nil
+	}
+
+	if err := c.wal.append(record); err != nil {
+		return err
+	}
+
+	applyErr := apply()
+	if applyErr == nil {
+		// the event is recorded before the record is discarded, so a crash in between can't lose it
+		c.recordChange(record)
+	}
+
+	if err := c.wal.reset(); err != nil {
+		// the record stays in the log and is replayed on the next Init, which is harmless because replay is idempotent
+		c.logger.Error("error resetting write-ahead log", zap.Error(err))
+	}
+
+	return applyErr
+}
+
+// recoverWriteAheadLog replays entries that were logged but possibly not applied before a crash.
+func (c *FlatDBCollection[T]) recoverWriteAheadLog() error {
+	if c.wal == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := c.wal.readEntries()
+	if err != nil {
+		return err
+	}
+
+	if len(entries) > 0 {
+		c.logger.Info("replaying write-ahead log", zap.Int("entries", len(entries)))
+	}
+
+	for _, records := range entries {
+		for _, record := range records {
+			if err := c.replayRecord(record); err != nil {
+				return fmt.Errorf("error replaying write-ahead log: %w", err)
+			}
+			if err := c.replayChange(record); err != nil {
+				return fmt.Errorf("error replaying write-ahead log: %w", err)
+			}
+		}
+	}
+
+	return c.wal.reset()
+}
+
+// replayRecord applies record to the collection files. Caller must hold c.mu.
+func (c *FlatDBCollection[T]) replayRecord(record walRecord) error {
+	switch record.Op {
+	case walOpInsert:
+		if err := c.writeDocument(record.Data, record.ID); err != nil {
+			return err
+		}
+	case walOpUpdate:
+		if err := c.invalidateIndexSnapshot(record.ID); err != nil {
+			return err
+		}
+		if err := c.writeDocument(record.Data, record.ID); err != nil {
+			return err
+		}
+	case walOpDelete:
+		if err := c.invalidateIndexSnapshot(record.ID); err != nil {
+			return err
+		}
+		if err := c.removeDocument(record.ID); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown write-ahead log operation %d", record.Op)
+	}
+
+	curID, err := readID(c.idFile)
+	if err != nil {
+		return err
+	}
+
+	if record.ID > curID {
+		return writeID(c.idFile, record.ID)
+	}
+
+	return nil
+}
+
+func errorOpeningWriteAheadLog(path string, err error) error {
+	return fmt.Errorf("error opening write-ahead log %s: %w", path, err)
+}
+
+func errorAppendingToWriteAheadLog(err error) error {
+	return fmt.Errorf("error appending to write-ahead log: %w", err)
+}
+
+func errorReadingWriteAheadLog(err error) error {
+	return fmt.Errorf("error reading write-ahead log: %w", err)
+}
@@ -0,0 +1,105 @@
+package goflatdb
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestFlatDBCollectionWriteAheadLog(t *testing.T) {
+	t.Run("writes work and leave the log empty", func(t *testing.T) {
+		dir := t.TempDir()
+
+		logger, err := zap.NewDevelopment()
+		require.NoError(t, err)
+
+		db, err := NewFlatDB(dir, logger)
+		require.NoError(t, err)
+
+		col, err := NewFlatDBCollection[testData](db, "test-collection", logger, WithWriteAheadLog[testData]())
+		require.NoError(t, err)
+
+		_, err = col.Insert(&testData{Foo: "hello"})
+		require.NoError(t, err)
+		require.NoError(t, col.Update(1, &testData{Foo: "world"}))
+		_, err = col.Insert(&testData{Foo: "hello"})
+		require.NoError(t, err)
+		require.NoError(t, col.Delete(2))
+
+		res, err := col.GetByID(1)
+		require.NoError(t, err)
+		require.Equal(t, testData{Foo: "world"}, res.Data)
+
+		stat, err := os.Stat(filepath.Join(dir, "test-collection", walFileName))
+		require.NoError(t, err)
+		require.Equal(t, int64(0), stat.Size())
+	})
+
+	t.Run("Init replays complete entries and rolls back torn ones", func(t *testing.T) {
+		dir := t.TempDir()
+
+		logger, err := zap.NewDevelopment()
+		require.NoError(t, err)
+
+		db, err := NewFlatDB(dir, logger)
+		require.NoError(t, err)
+
+		{
+			col, err := NewFlatDBCollection[testData](db, "test-collection", logger, WithWriteAheadLog[testData]())
+			require.NoError(t, err)
+
+			_, err = col.Insert(&testData{Foo: "hello"})
+			require.NoError(t, err)
+			_, err = col.Insert(&testData{Foo: "hello"})
+			require.NoError(t, err)
+
+			require.NoError(t, col.Close())
+		}
+
+		// simulate a crash after the intents were logged, but before they were applied
+		{
+			wal, err := openWriteAheadLog(filepath.Join(dir, "test-collection", walFileName), DurabilityNone)
+			require.NoError(t, err)
+
+			updated, err := json.Marshal(FlatDBModel[testData]{Data: testData{Foo: "world"}, ID: 1})
+			require.NoError(t, err)
+			inserted, err := json.Marshal(FlatDBModel[testData]{Data: testData{Foo: "world"}, ID: 3})
+			require.NoError(t, err)
+
+			require.NoError(t, wal.append(walRecord{Op: walOpUpdate, ID: 1, Data: updated}))
+			require.NoError(t, wal.append(walRecord{Op: walOpDelete, ID: 2}))
+			require.NoError(t, wal.append(walRecord{Op: walOpInsert, ID: 3, Data: inserted}))
+
+			_, err = wal.f.Write([]byte{0, 0, 1, 0, 1, 2, 3, 4, '[', '{'})
+			require.NoError(t, err)
+
+			require.NoError(t, wal.Close())
+		}
+
+		col, err := NewFlatDBCollection[testData](db, "test-collection", logger,
+			WithWriteAheadLog[testData](), WithUnorderedIndex[testData]("Foo"))
+		require.NoError(t, err)
+
+		_, err = col.GetByID(2)
+		require.ErrorIs(t, err, DocumentNotFound)
+
+		docs, err := col.findBy("Foo", "world")
+		require.NoError(t, err)
+		require.Equal(t, 2, len(docs))
+
+		_, err = col.findBy("Foo", "hello")
+		require.ErrorIs(t, err, DocumentNotFound)
+
+		res, err := col.Insert(&testData{Foo: "hello"})
+		require.NoError(t, err)
+		require.Equal(t, InsertResult{ID: 4}, res)
+
+		stat, err := os.Stat(filepath.Join(dir, "test-collection", walFileName))
+		require.NoError(t, err)
+		require.Equal(t, int64(0), stat.Size())
+	})
+}